@@ -0,0 +1,111 @@
+package graphql
+
+import (
+	"encoding/json"
+
+	"github.com/google/uuid"
+)
+
+// sharedOperation is a server operation shared by identical subscriptions.
+// Its subscribers are guarded by the client mutex
+type sharedOperation struct {
+	key         string
+	subscribers map[string]*subscription
+}
+
+// WithDeduplication enables sharing a single server operation among the subscriptions with the same query and variables.
+// Every message of the operation is sent to the handlers of all its subscribers,
+// and the operation is stopped when the last subscriber unsubscribes.
+// A subscriber joining a running operation only receives the next messages.
+// After the server fails the operation, identical subscriptions start a new one. Default false
+func (sc *SubscriptionClient) WithDeduplication(enabled bool) *SubscriptionClient {
+	sc.deduplicate = enabled
+	return sc
+}
+
+// subscriptionKey identifies identical subscriptions. The keys of variables are encoded in sorted order
+func subscriptionKey(query string, variables map[string]any) (string, bool) {
+	bVariables, err := json.Marshal(variables)
+	if err != nil {
+		return "", false
+	}
+	return query + "\x00" + string(bVariables), true
+}
+
+// addSharedSubscriber adds the subscriber id to the server operation of key. The operation is started if it doesn't exist
+func (sc *SubscriptionClient) addSharedSubscriber(key string, id string, subscriber *subscription) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if opID, ok := sc.sharedKeys[key]; ok {
+		sc.subscriptions[opID].shared.subscribers[id] = subscriber
+		sc.sharedIDs[id] = opID
+		return nil
+	}
+
+	shared := &sharedOperation{
+		key:         key,
+		subscribers: map[string]*subscription{id: subscriber},
+	}
+	opID := uuid.New().String()
+	sub := &subscription{
+		query:     subscriber.query,
+		variables: subscriber.variables,
+		handler: func(data []byte, err error) {
+			for _, s := range sc.sharedSubscribers(shared) {
+				s.handler(data, err)
+			}
+		},
+		shared: shared,
+		stats:  subscriptionStats{createdAt: subscriber.stats.createdAt},
+	}
+	if err := sc.registerSubscription(opID, sub); err != nil {
+		return err
+	}
+	sc.sharedKeys[key] = opID
+	sc.sharedIDs[id] = opID
+
+	return nil
+}
+
+// sharedSubscribers returns the current subscribers of the shared operation
+func (sc *SubscriptionClient) sharedSubscribers(shared *sharedOperation) []*subscription {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	subscribers := make([]*subscription, 0, len(shared.subscribers))
+	for _, s := range shared.subscribers {
+		subscribers = append(subscribers, s)
+	}
+	return subscribers
+}
+
+// removeSharedSubscriber removes the subscriber id from its server operation. The caller must hold mu.
+// It returns true if it was the last subscriber, so the operation must be stopped
+func (sc *SubscriptionClient) removeSharedSubscriber(id string) bool {
+	opID := sc.sharedIDs[id]
+	delete(sc.sharedIDs, id)
+
+	sub, ok := sc.subscriptions[opID]
+	if !ok {
+		return false
+	}
+	delete(sub.shared.subscribers, id)
+	return len(sub.shared.subscribers) == 0
+}
+
+// forgetSharedOperation removes the shared operation opID and its remaining subscribers. The caller must hold mu
+func (sc *SubscriptionClient) forgetSharedOperation(opID string, shared *sharedOperation) {
+	sc.releaseSharedKey(opID, shared)
+	for id := range shared.subscribers {
+		delete(sc.sharedIDs, id)
+	}
+}
+
+// releaseSharedKey removes the key of the shared operation opID, so that identical subscriptions start a new operation.
+// The key is kept if it was taken by another operation. The caller must hold mu
+func (sc *SubscriptionClient) releaseSharedKey(opID string, shared *sharedOperation) {
+	if sc.sharedKeys[shared.key] == opID {
+		delete(sc.sharedKeys, shared.key)
+	}
+}
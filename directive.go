@@ -0,0 +1,158 @@
+package graphql
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Variable is a directive argument referencing an operation variable, rendered as $name
+type Variable string
+
+// EnumValue is a directive argument rendered as an enum value, without quotes
+type EnumValue string
+
+// Directive is a GraphQL directive with its arguments.
+// It's an operation directive option, and its String method renders the directive
+// for the dynamic field keys of ordered map queries
+type Directive struct {
+	text string
+	// err is returned by the query construction if an argument can't be rendered
+	err error
+}
+
+// OperationDirective creates a directive, rendering the arguments as GraphQL values, ordered by name.
+// Arguments may be strings, numbers, booleans, nil, Variable, EnumValue, slices, arrays and maps with string keys.
+// Other values are rendered like their JSON encoding
+func OperationDirective(name string, args map[string]any) Directive {
+	text := "@" + strings.TrimPrefix(name, "@")
+	if len(args) == 0 {
+		return Directive{text: text}
+	}
+
+	keys := make([]string, 0, len(args))
+	for key := range args {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		value, err := renderValue(args[key])
+		if err != nil {
+			return Directive{
+				text: text,
+				err:  fmt.Errorf("invalid argument %s of directive %s: %w", key, text, err),
+			}
+		}
+		parts[i] = key + ": " + value
+	}
+
+	return Directive{text: fmt.Sprintf("%s(%s)", text, strings.Join(parts, ", "))}
+}
+
+func (d Directive) Type() OptionType {
+	return OptionTypeOperationDirective
+}
+
+func (d Directive) String() string {
+	return d.text
+}
+
+// renderValue renders v as a GraphQL input value
+func renderValue(v any) (string, error) {
+	switch val := v.(type) {
+	case nil:
+		return "null", nil
+	case Variable:
+		return "$" + strings.TrimPrefix(string(val), "$"), nil
+	case EnumValue:
+		return string(val), nil
+	case json.Number:
+		return val.String(), nil
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return "null", nil
+		}
+		return renderValue(rv.Elem().Interface())
+	case reflect.Bool:
+		return strconv.FormatBool(rv.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(rv.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		f := rv.Float()
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return "", fmt.Errorf("%v isn't a valid GraphQL float", f)
+		}
+		return strconv.FormatFloat(f, 'g', -1, 64), nil
+	case reflect.String:
+		return quoteString(rv.String())
+	case reflect.Slice, reflect.Array:
+		items := make([]string, rv.Len())
+		for i := range items {
+			item, err := renderValue(rv.Index(i).Interface())
+			if err != nil {
+				return "", err
+			}
+			items[i] = item
+		}
+		return "[" + strings.Join(items, ", ") + "]", nil
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return "", fmt.Errorf("map keys must be strings, got %v", rv.Type().Key())
+		}
+		keys := rv.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+		fields := make([]string, len(keys))
+		for i, key := range keys {
+			field, err := renderValue(rv.MapIndex(key).Interface())
+			if err != nil {
+				return "", err
+			}
+			fields[i] = key.String() + ": " + field
+		}
+		return "{" + strings.Join(fields, ", ") + "}", nil
+	default:
+		return renderJSONValue(v)
+	}
+}
+
+// renderJSONValue renders v like its JSON encoding
+func renderJSONValue(v any) (string, error) {
+	bValue, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(bValue))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return "", err
+	}
+	return renderValue(value)
+}
+
+// quoteString renders s as a GraphQL string. JSON escape sequences are valid in GraphQL strings
+func quoteString(s string) (string, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(s); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
@@ -0,0 +1,92 @@
+package graphql
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOperationDirective(t *testing.T) {
+	type input struct {
+		Name  string `json:"name"`
+		Count int    `json:"count,omitempty"`
+	}
+
+	tests := []struct {
+		name string
+		args map[string]any
+		want string
+	}{
+		{
+			name: "cached",
+			want: "@cached",
+		},
+		{
+			name: "@cached",
+			args: map[string]any{"ttl": 60},
+			want: "@cached(ttl: 60)",
+		},
+		{
+			name: "example",
+			args: map[string]any{
+				"string":   "say \"hi\"\n<b>",
+				"float":    Float(1.5),
+				"bool":     NewBoolean(true),
+				"null":     nil,
+				"enum":     EnumValue("DESC"),
+				"variable": Variable("withDetails"),
+				"list":     []any{1, "a", Variable("$id")},
+				"object":   map[string]any{"b": ID("1"), "a": []int{}},
+				"struct":   input{Name: "test"},
+				"time":     time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+			},
+			want: `@example(bool: true, enum: DESC, float: 1.5, list: [1, "a", $id], null: null, object: {a: [], b: "1"}, string: "say \"hi\"\n<b>", struct: {name: "test"}, time: "2024-01-02T03:04:05Z", variable: $withDetails)`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := OperationDirective(tt.name, tt.args).String()
+			if got != tt.want {
+				t.Errorf("\ngot:  %s\nwant: %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOperationDirective_Query(t *testing.T) {
+	var q struct {
+		User struct {
+			Name string
+		}
+	}
+
+	got, err := ConstructQuery(&q, nil, OperationDirective("cached", map[string]any{"ttl": 60}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `query  @cached(ttl: 60) {user{name}}`; got != want {
+		t.Errorf("\ngot:  %q\nwant: %q", got, want)
+	}
+
+	// the directive renders the keys of ordered map queries
+	include := OperationDirective("include", map[string]any{"if": Variable("withEmail")})
+	om := [][2]any{
+		{"user", [][2]any{
+			{"email " + include.String(), String("")},
+		}},
+	}
+	got, err = ConstructQuery(&om, map[string]any{"withEmail": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `query ($withEmail:Boolean!){user{email @include(if: $withEmail)}}`; got != want {
+		t.Errorf("\ngot:  %q\nwant: %q", got, want)
+	}
+
+	_, err = ConstructQuery(&q, nil, OperationDirective("cached", map[string]any{"ttl": math.Inf(1)}))
+	if err == nil || !strings.Contains(err.Error(), "invalid argument ttl of directive @cached") {
+		t.Errorf("got error: %v, want: invalid argument ttl", err)
+	}
+}
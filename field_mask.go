@@ -0,0 +1,182 @@
+package graphql
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/llehouerou/go-graphql-client/internal/tagparser"
+)
+
+// fieldMaskOption represents the field paths selected or excluded by the query. It doesn't render anything
+type fieldMaskOption struct {
+	paths   []string
+	exclude bool
+}
+
+func (fmo fieldMaskOption) Type() OptionType {
+	return optionTypeFieldMask
+}
+
+func (fmo fieldMaskOption) String() string {
+	return ""
+}
+
+// Fields creates the option which restricts the query constructed from a struct to the fields at paths,
+// with their subfields. A path joins the names of the fields with dots, e.g. "user.posts.title",
+// the alias of a field replaces its name.
+//
+// The named fragments whose fields are pruned by Fields or Exclude are written as inline fragments,
+// so that the other spreads of the fragment keep all its fields.
+//
+// The fields which aren't selected are omitted from the query, so they keep their zero value
+// when the response is decoded. The variables referenced only by these fields are omitted
+// from the operation, and from the request variables of the clients.
+func Fields(paths ...string) Option {
+	return fieldMaskOption{paths: paths}
+}
+
+// Exclude creates the option which omits the fields at paths, with their subfields,
+// from the query constructed from a struct. The paths are written as in Fields
+func Exclude(paths ...string) Option {
+	return fieldMaskOption{paths: paths, exclude: true}
+}
+
+// fieldMask prunes the struct fields of a query by their path
+type fieldMask struct {
+	include [][]string
+	exclude [][]string
+	// paths are the paths of the options, in order
+	paths []string
+	// matched records the paths which match a field of the query
+	matched map[string]bool
+	// path holds the names of the fields being written
+	path []string
+}
+
+// add adds the paths of option to m, which is created if nil
+func (m *fieldMask) add(option fieldMaskOption) (*fieldMask, error) {
+	if m == nil {
+		m = &fieldMask{matched: make(map[string]bool)}
+	}
+	for _, path := range option.paths {
+		segments := strings.Split(path, ".")
+		for _, segment := range segments {
+			if segment == "" {
+				return nil, fmt.Errorf("invalid field path %q", path)
+			}
+		}
+		if option.exclude {
+			m.exclude = append(m.exclude, segments)
+		} else {
+			m.include = append(m.include, segments)
+		}
+		m.paths = append(m.paths, path)
+	}
+	return m, nil
+}
+
+// selects reports whether the field name, child of the current field, is selected
+func (m *fieldMask) selects(name string) bool {
+	if m == nil {
+		return true
+	}
+	path := append(m.path[:len(m.path):len(m.path)], name)
+	for _, excluded := range m.exclude {
+		if hasPathPrefix(path, excluded) {
+			m.matched[strings.Join(excluded, ".")] = true
+			return false
+		}
+	}
+	if len(m.include) == 0 {
+		return true
+	}
+	selected := false
+	for _, included := range m.include {
+		// the parents of the included field are selected, as well as its subfields
+		if hasPathPrefix(path, included) || hasPathPrefix(included, path) {
+			selected = true
+			if len(path) == len(included) {
+				m.matched[strings.Join(included, ".")] = true
+			}
+		}
+	}
+	return selected
+}
+
+// prunes reports whether the subfields of the current field may be pruned
+func (m *fieldMask) prunes() bool {
+	if m == nil {
+		return false
+	}
+	for _, excluded := range m.exclude {
+		if len(excluded) > len(m.path) && hasPathPrefix(excluded, m.path) {
+			return true
+		}
+	}
+	if len(m.include) == 0 {
+		return false
+	}
+	for _, included := range m.include {
+		// all the subfields of an included field are selected
+		if hasPathPrefix(m.path, included) {
+			return false
+		}
+	}
+	return true
+}
+
+// push enters the field name
+func (m *fieldMask) push(name string) {
+	if m != nil {
+		m.path = append(m.path, name)
+	}
+}
+
+// pop leaves the current field
+func (m *fieldMask) pop() {
+	if m != nil {
+		m.path = m.path[:len(m.path)-1]
+	}
+}
+
+// validate returns an error if a path doesn't match any field of the query
+func (m *fieldMask) validate() error {
+	if m == nil {
+		return nil
+	}
+	for _, path := range m.paths {
+		if !m.matched[path] {
+			return fmt.Errorf("field path %q doesn't match any field of the query", path)
+		}
+	}
+	return nil
+}
+
+// hasPathPrefix reports whether path starts with prefix
+func hasPathPrefix(path []string, prefix []string) bool {
+	if len(path) < len(prefix) {
+		return false
+	}
+	for i, segment := range prefix {
+		if path[i] != segment {
+			return false
+		}
+	}
+	return true
+}
+
+// fieldPathName returns the name of the struct field in the field paths, which is its alias or its name.
+// It returns false for the fields inlined into their parent, like the inline fragments
+func fieldPathName(output fieldOutput) (string, bool) {
+	if output.isInline {
+		return "", false
+	}
+	parsed, err := tagparser.ParseGraphQLTag(output.name)
+	if err != nil || parsed.IsFragment {
+		return "", false
+	}
+	if parsed.Alias != "" {
+		return parsed.Alias, true
+	}
+	return parsed.FieldName, true
+}
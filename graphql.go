@@ -42,6 +42,7 @@ type Client struct {
 	httpClient      *http.Client
 	requestModifier RequestModifier
 	debug           bool
+	scalars         *ScalarRegistry
 }
 
 // NewClient creates a GraphQL client targeting the specified GraphQL server URL.
@@ -128,12 +129,16 @@ func (c *Client) buildAndRequest(
 	options ...Option,
 ) ([]byte, *http.Response, io.Reader, Errors) {
 	var query string
+	var omitted map[string]bool
 	var err error
 	switch op {
 	case queryOperation:
-		query, err = ConstructQuery(v, variables, options...)
+		query, omitted, err = constructOperation("query", v, variables, false, c.scalars, options...)
 	case mutationOperation:
-		query, err = ConstructMutation(v, variables, options...)
+		query, omitted, err = constructOperation("mutation", v, variables, true, c.scalars, options...)
+	}
+	if err == nil {
+		variables, err = omitVariables(variables, omitted, c.scalars)
 	}
 
 	if err != nil {
@@ -296,6 +301,10 @@ func (c *Client) BuildRequest(
 	if !hasVariables(variables) {
 		variables = nil
 	}
+	variables, err := c.scalars.encodeVariables(variables)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode variables: %w", err)
+	}
 	in := struct {
 		Query     string `json:"query"`
 		Variables any    `json:"variables,omitempty"`
@@ -304,7 +313,7 @@ func (c *Client) BuildRequest(
 		Variables: variables,
 	}
 	var buf bytes.Buffer
-	err := json.NewEncoder(&buf).Encode(in)
+	err = json.NewEncoder(&buf).Encode(in)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -360,6 +369,76 @@ func (c *Client) ExecuteRequest(req *http.Request) (*http.Response, io.Reader, e
 	return resp, r, nil
 }
 
+// streamRequest is the body of the requests of the HTTP subscription transports
+type streamRequest struct {
+	Query      string         `json:"query"`
+	Variables  map[string]any `json:"variables,omitempty"`
+	Extensions map[string]any `json:"extensions,omitempty"`
+}
+
+// newStreamRequest builds a request of the HTTP subscription transports, with the JSON encoded body if not nil.
+// The request modifier is applied after the given headers
+func (c *Client) newStreamRequest(
+	ctx context.Context,
+	method string,
+	header http.Header,
+	body any,
+) (*http.Request, []byte, error) {
+	var reqBody []byte
+	if body != nil {
+		var err error
+		reqBody, err = json.Marshal(body)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, c.url, bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, reqBody, err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	for key, values := range header {
+		request.Header[key] = values
+	}
+
+	if c.requestModifier != nil {
+		c.requestModifier(request)
+	}
+
+	return request, reqBody, nil
+}
+
+// checkStreamResponse returns the errors of a response whose status code isn't the expected one.
+// GraphQL errors sent in the body are returned as is
+func (c *Client) checkStreamResponse(
+	resp *http.Response,
+	status int,
+	req *http.Request,
+	reqBody []byte,
+) Errors {
+	if resp.StatusCode == status {
+		return nil
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	if _, errs := c.DecodeResponse(bytes.NewReader(body)); len(errs) > 0 &&
+		errs[0].GetCode() != ErrJsonDecode {
+		return errs
+	}
+
+	return Errors{c.NewRequestError(
+		ErrRequestError,
+		fmt.Errorf("%v; body: %q", resp.Status, body),
+		req,
+		resp,
+		bytes.NewReader(reqBody),
+		bytes.NewReader(body),
+	)}
+}
+
 // DecodeResponse decodes a GraphQL JSON response into raw data and errors.
 // It returns the raw data bytes (if present) and any GraphQL errors.
 func (c *Client) DecodeResponse(reader io.Reader) ([]byte, Errors) {
@@ -454,7 +533,7 @@ func (c *Client) processResponse(
 	errs Errors,
 ) error {
 	if len(data) > 0 {
-		err := jsonutil.UnmarshalGraphQL(data, v)
+		err := c.scalars.UnmarshalGraphQL(data, v)
 		if err != nil {
 			we := c.DecorateError(
 				newError(ErrGraphQLDecode, err),
@@ -482,6 +561,7 @@ func (c *Client) clone() *Client {
 		httpClient:      c.httpClient,
 		requestModifier: c.requestModifier,
 		debug:           c.debug,
+		scalars:         c.scalars,
 	}
 }
 
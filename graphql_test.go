@@ -2538,3 +2538,75 @@ func TestClient_ExecuteRequest(t *testing.T) {
 		}
 	})
 }
+
+func TestClient_Query_fieldMask(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/graphql", func(w http.ResponseWriter, req *http.Request) {
+		body := mustRead(req.Body)
+		if got, want := body, `{"query":"{user{name}}"}`+"\n"; got != want {
+			t.Errorf("got body: %v, want %v", got, want)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		mustWrite(w, `{"data": {"user": {"name": "Gopher"}}}`)
+	})
+	client := graphql.NewClient(
+		"/graphql",
+		&http.Client{Transport: localRoundTripper{handler: mux}},
+	)
+
+	var q struct {
+		User struct {
+			Name  string
+			Email string
+			Posts []struct {
+				Title string
+			}
+		}
+	}
+	if err := client.Query(context.Background(), &q, nil, graphql.Exclude("user.email", "user.posts")); err != nil {
+		t.Fatal(err)
+	}
+	if q.User.Name != "Gopher" || q.User.Email != "" || q.User.Posts != nil {
+		t.Errorf("got user: %+v, want only the name", q.User)
+	}
+}
+
+func TestClient_Query_fieldMaskVariables(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/graphql", func(w http.ResponseWriter, req *http.Request) {
+		body := mustRead(req.Body)
+		if got, want := body, `{"query":"query ($id:ID!){user(id: $id){name}}","variables":{"id":"1"}}`+"\n"; got != want {
+			t.Errorf("got body: %v, want %v", got, want)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		mustWrite(w, `{"data": {"user": {"name": "Gopher"}}}`)
+	})
+	client := graphql.NewClient(
+		"/graphql",
+		&http.Client{Transport: localRoundTripper{handler: mux}},
+	)
+
+	var q struct {
+		User struct {
+			Name  string
+			Posts []struct {
+				Title string
+			} `graphql:"posts(first: $first)"`
+		} `graphql:"user(id: $id)"`
+	}
+	variables := map[string]any{
+		"id":    graphql.ID("1"),
+		"first": 10,
+	}
+	if err := client.Query(context.Background(), &q, variables, graphql.Fields("user.name")); err != nil {
+		t.Fatal(err)
+	}
+
+	structVariables := struct {
+		ID    graphql.ID `json:"id"`
+		First int        `json:"first"`
+	}{ID: "1", First: 10}
+	if err := client.Query(context.Background(), &q, structVariables, graphql.Fields("user.name")); err != nil {
+		t.Fatal(err)
+	}
+}
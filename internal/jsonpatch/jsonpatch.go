@@ -0,0 +1,310 @@
+// Package jsonpatch applies JSON Patch documents (RFC 6902) to decoded JSON values.
+// Documents are represented as decoded by Decode: map[string]any, []any, json.Number,
+// string, bool and nil.
+package jsonpatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Operation is an operation of a JSON Patch document
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Decode decodes the JSON data, keeping numbers as json.Number so they are encoded back unchanged
+func Decode(data []byte) (any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var v any
+	if err := decoder.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Apply applies the operations to doc in order and returns the patched document.
+// doc may be modified in place, even if an error is returned
+func Apply(doc any, patch []Operation) (any, error) {
+	for i, op := range patch {
+		var err error
+		doc, err = apply(doc, op)
+		if err != nil {
+			return nil, fmt.Errorf("patch operation %d (%s %s): %w", i, op.Op, op.Path, err)
+		}
+	}
+	return doc, nil
+}
+
+func apply(doc any, op Operation) (any, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case "add", "replace", "test":
+		if len(op.Value) == 0 {
+			return nil, fmt.Errorf("missing value")
+		}
+		value, err := Decode(op.Value)
+		if err != nil {
+			return nil, err
+		}
+		switch op.Op {
+		case "add":
+			return add(doc, path, value)
+		case "replace":
+			return replace(doc, path, value)
+		default:
+			return doc, test(doc, path, value)
+		}
+	case "remove":
+		doc, _, err = remove(doc, path)
+		return doc, err
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "move" {
+			if isPrefix(from, path) && len(from) < len(path) {
+				return nil, fmt.Errorf("can't move %s into one of its children", op.From)
+			}
+			var value any
+			doc, value, err = remove(doc, from)
+			if err != nil {
+				return nil, err
+			}
+			return add(doc, path, value)
+		}
+		value, err := get(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, path, deepCopy(value))
+	default:
+		return nil, fmt.Errorf("unknown operation")
+	}
+}
+
+// parsePointer splits the JSON Pointer (RFC 6901) into its unescaped tokens
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if pointer[0] != '/' {
+		return nil, fmt.Errorf("invalid JSON pointer %q", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func isPrefix(prefix, path []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// index parses the array index of the token. If end is true, "-" refers to the length of the array
+func index(token string, length int, end bool) (int, error) {
+	if end && token == "-" {
+		return length, nil
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (token != "0" && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	last := length - 1
+	if end {
+		last = length
+	}
+	if i > last {
+		return 0, fmt.Errorf("array index %d out of bounds", i)
+	}
+	return i, nil
+}
+
+func get(doc any, path []string) (any, error) {
+	node := doc
+	for _, token := range path {
+		switch n := node.(type) {
+		case map[string]any:
+			child, ok := n[token]
+			if !ok {
+				return nil, fmt.Errorf("member %q not found", token)
+			}
+			node = child
+		case []any:
+			i, err := index(token, len(n), false)
+			if err != nil {
+				return nil, err
+			}
+			node = n[i]
+		default:
+			return nil, fmt.Errorf("can't get %q of a scalar value", token)
+		}
+	}
+	return node, nil
+}
+
+// update calls fn with the parent container of the path and the last token,
+// then stores the returned container in the document, because appending to arrays may reallocate them
+func update(
+	doc any,
+	path []string,
+	fn func(container any, token string) (any, error),
+) (any, error) {
+	if len(path) == 1 {
+		return fn(doc, path[0])
+	}
+
+	child, err := get(doc, path[:1])
+	if err != nil {
+		return nil, err
+	}
+	child, err = update(child, path[1:], fn)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n := doc.(type) {
+	case map[string]any:
+		n[path[0]] = child
+	case []any:
+		i, _ := index(path[0], len(n), false)
+		n[i] = child
+	}
+	return doc, nil
+}
+
+func add(doc any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	return update(doc, path, func(container any, token string) (any, error) {
+		switch n := container.(type) {
+		case map[string]any:
+			n[token] = value
+			return n, nil
+		case []any:
+			i, err := index(token, len(n), true)
+			if err != nil {
+				return nil, err
+			}
+			n = append(n, nil)
+			copy(n[i+1:], n[i:])
+			n[i] = value
+			return n, nil
+		default:
+			return nil, fmt.Errorf("can't add %q to a scalar value", token)
+		}
+	})
+}
+
+func replace(doc any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	return update(doc, path, func(container any, token string) (any, error) {
+		switch n := container.(type) {
+		case map[string]any:
+			if _, ok := n[token]; !ok {
+				return nil, fmt.Errorf("member %q not found", token)
+			}
+			n[token] = value
+			return n, nil
+		case []any:
+			i, err := index(token, len(n), false)
+			if err != nil {
+				return nil, err
+			}
+			n[i] = value
+			return n, nil
+		default:
+			return nil, fmt.Errorf("can't replace %q of a scalar value", token)
+		}
+	})
+}
+
+// remove deletes the value at path, and returns the patched document and the removed value
+func remove(doc any, path []string) (any, any, error) {
+	if len(path) == 0 {
+		return nil, doc, nil
+	}
+
+	var removed any
+	doc, err := update(doc, path, func(container any, token string) (any, error) {
+		switch n := container.(type) {
+		case map[string]any:
+			value, ok := n[token]
+			if !ok {
+				return nil, fmt.Errorf("member %q not found", token)
+			}
+			removed = value
+			delete(n, token)
+			return n, nil
+		case []any:
+			i, err := index(token, len(n), false)
+			if err != nil {
+				return nil, err
+			}
+			removed = n[i]
+			return append(n[:i], n[i+1:]...), nil
+		default:
+			return nil, fmt.Errorf("can't remove %q of a scalar value", token)
+		}
+	})
+	return doc, removed, err
+}
+
+func test(doc any, path []string, value any) error {
+	actual, err := get(doc, path)
+	if err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(actual, value) {
+		return fmt.Errorf("test failed")
+	}
+	return nil
+}
+
+func deepCopy(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		c := make(map[string]any, len(v))
+		for key, child := range v {
+			c[key] = deepCopy(child)
+		}
+		return c
+	case []any:
+		c := make([]any, len(v))
+		for i, child := range v {
+			c[i] = deepCopy(child)
+		}
+		return c
+	default:
+		return v
+	}
+}
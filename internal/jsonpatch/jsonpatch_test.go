@@ -0,0 +1,139 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func applyJSON(t *testing.T, doc string, patch string) (string, error) {
+	t.Helper()
+
+	v, err := Decode([]byte(doc))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var ops []Operation
+	if err := json.Unmarshal([]byte(patch), &ops); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	v, err = Apply(v, ops)
+	if err != nil {
+		return "", err
+	}
+	out, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return string(out), nil
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+		want  string
+	}{
+		{
+			name:  "add member",
+			doc:   `{"a":1}`,
+			patch: `[{"op":"add","path":"/b","value":{"c":[1]}}]`,
+			want:  `{"a":1,"b":{"c":[1]}}`,
+		},
+		{
+			name:  "add array element",
+			doc:   `{"a":[1,3]}`,
+			patch: `[{"op":"add","path":"/a/1","value":2},{"op":"add","path":"/a/-","value":4}]`,
+			want:  `{"a":[1,2,3,4]}`,
+		},
+		{
+			name:  "add nested array element",
+			doc:   `{"a":{"b":[]}}`,
+			patch: `[{"op":"add","path":"/a/b/0","value":"x"}]`,
+			want:  `{"a":{"b":["x"]}}`,
+		},
+		{
+			name:  "remove",
+			doc:   `{"a":[1,2,3],"b":true}`,
+			patch: `[{"op":"remove","path":"/a/1"},{"op":"remove","path":"/b"}]`,
+			want:  `{"a":[1,3]}`,
+		},
+		{
+			name:  "replace",
+			doc:   `{"a":{"b":1}}`,
+			patch: `[{"op":"replace","path":"/a/b","value":12345678901234567890}]`,
+			want:  `{"a":{"b":12345678901234567890}}`,
+		},
+		{
+			name:  "replace root",
+			doc:   `{"a":1}`,
+			patch: `[{"op":"replace","path":"","value":[null]}]`,
+			want:  `[null]`,
+		},
+		{
+			name:  "move",
+			doc:   `{"a":{"b":1},"c":[]}`,
+			patch: `[{"op":"move","from":"/a/b","path":"/c/0"}]`,
+			want:  `{"a":{},"c":[1]}`,
+		},
+		{
+			name:  "copy",
+			doc:   `{"a":{"b":[1]}}`,
+			patch: `[{"op":"copy","from":"/a","path":"/c"},{"op":"add","path":"/c/b/-","value":2}]`,
+			want:  `{"a":{"b":[1]},"c":{"b":[1,2]}}`,
+		},
+		{
+			name:  "test",
+			doc:   `{"a":"x"}`,
+			patch: `[{"op":"test","path":"/a","value":"x"}]`,
+			want:  `{"a":"x"}`,
+		},
+		{
+			name:  "escaped pointer",
+			doc:   `{"a/b":{"c~d":1}}`,
+			patch: `[{"op":"replace","path":"/a~1b/c~0d","value":2}]`,
+			want:  `{"a/b":{"c~d":2}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := applyJSON(t, tt.doc, tt.patch)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestApply_Errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+	}{
+		{"unknown operation", `{}`, `[{"op":"merge","path":"/a","value":1}]`},
+		{"invalid pointer", `{}`, `[{"op":"add","path":"a","value":1}]`},
+		{"missing value", `{}`, `[{"op":"add","path":"/a"}]`},
+		{"remove missing member", `{}`, `[{"op":"remove","path":"/a"}]`},
+		{"replace missing member", `{}`, `[{"op":"replace","path":"/a","value":1}]`},
+		{"index out of bounds", `{"a":[]}`, `[{"op":"add","path":"/a/1","value":1}]`},
+		{"invalid index", `{"a":[1]}`, `[{"op":"replace","path":"/a/01","value":1}]`},
+		{"missing parent", `{}`, `[{"op":"add","path":"/a/b","value":1}]`},
+		{"scalar parent", `{"a":1}`, `[{"op":"add","path":"/a/b","value":1}]`},
+		{"move into child", `{"a":{}}`, `[{"op":"move","from":"/a","path":"/a/b"}]`},
+		{"failed test", `{"a":1}`, `[{"op":"test","path":"/a","value":2}]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := applyJSON(t, tt.doc, tt.patch); err == nil {
+				t.Error("expected error, got nil")
+			}
+		})
+	}
+}
@@ -177,3 +177,28 @@ func GetGraphQLTypeFromType(t reflect.Type) (string, bool) {
 
 	return graphqlType.GetGraphQLType(), true
 }
+
+// GetGraphQLFragment extracts the fragment name and type condition of a struct type
+// implementing the GraphQLFragment interface, with a value or pointer receiver.
+// Pointers are dereferenced. Returns false if the type isn't a named fragment.
+func GetGraphQLFragment(t reflect.Type) (string, string, bool) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return "", "", false
+	}
+
+	var fragment types.GraphQLFragment
+	switch {
+	case t.Implements(types.GraphqlFragmentInterface):
+		fragment, _ = reflect.Zero(t).Interface().(types.GraphQLFragment)
+	case reflect.PointerTo(t).Implements(types.GraphqlFragmentInterface):
+		fragment, _ = reflect.New(t).Interface().(types.GraphQLFragment)
+	default:
+		return "", "", false
+	}
+
+	name, typeCondition := fragment.GetGraphQLFragment()
+	return name, typeCondition, name != ""
+}
@@ -527,3 +527,80 @@ func TestGetGraphQLType_interfaceValue(t *testing.T) {
 		t.Errorf("got: %q, want: %q", typeName, "CustomScalar")
 	}
 }
+
+// Test types for GraphQLFragment interface
+type UserFragment struct {
+	Name string
+}
+
+func (UserFragment) GetGraphQLFragment() (string, string) {
+	return "UserFields", "User"
+}
+
+type PointerFragment struct {
+	Name string
+}
+
+func (*PointerFragment) GetGraphQLFragment() (string, string) {
+	return "PointerFields", "Droid"
+}
+
+func TestGetGraphQLFragment(t *testing.T) {
+	tests := []struct {
+		name              string
+		typ               reflect.Type
+		wantName          string
+		wantTypeCondition string
+		wantOk            bool
+	}{
+		{
+			name:              "value receiver",
+			typ:               reflect.TypeOf(UserFragment{}),
+			wantName:          "UserFields",
+			wantTypeCondition: "User",
+			wantOk:            true,
+		},
+		{
+			name:              "pointer receiver",
+			typ:               reflect.TypeOf(PointerFragment{}),
+			wantName:          "PointerFields",
+			wantTypeCondition: "Droid",
+			wantOk:            true,
+		},
+		{
+			name:              "pointer to fragment",
+			typ:               reflect.TypeOf(&UserFragment{}),
+			wantName:          "UserFields",
+			wantTypeCondition: "User",
+			wantOk:            true,
+		},
+		{
+			name:   "RegularStruct isn't a fragment",
+			typ:    reflect.TypeOf(RegularStruct{}),
+			wantOk: false,
+		},
+		{
+			name:   "int isn't a fragment",
+			typ:    reflect.TypeOf(0),
+			wantOk: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, typeCondition, ok := GetGraphQLFragment(tt.typ)
+			if ok != tt.wantOk {
+				t.Errorf("GetGraphQLFragment() ok = %v, want %v", ok, tt.wantOk)
+			}
+			if name != tt.wantName || typeCondition != tt.wantTypeCondition {
+				t.Errorf(
+					"GetGraphQLFragment() = %q, %q, want %q, %q",
+					name,
+					typeCondition,
+					tt.wantName,
+					tt.wantTypeCondition,
+				)
+			}
+		})
+	}
+}
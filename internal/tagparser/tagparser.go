@@ -14,6 +14,8 @@ type ParsedTag struct {
 	IsFragment bool
 	// TypeName is the typename for fragments ("... on TypeName").
 	TypeName string
+	// Directives contains the field or fragment directives, e.g. "@include(if: $withDetails)".
+	Directives []string
 }
 
 // ParseGraphQLTag parses a GraphQL struct tag value and returns structured information.
@@ -22,10 +24,12 @@ type ParsedTag struct {
 //   - "height(unit: METER)" -> {FieldName: "height", Arguments: "unit: METER"}
 //   - "node1: node(id: $id)" -> {FieldName: "node", Alias: "node1", Arguments: "id: $id"}
 //   - "... on Droid" -> {IsFragment: true, TypeName: "Droid"}
+//   - "friends @include(if: $withFriends)" -> {FieldName: "friends", Directives: ["@include(if: $withFriends)"]}
 func ParseGraphQLTag(tag string) (ParsedTag, error) {
 	tag = strings.TrimSpace(tag)
 
 	var parsed ParsedTag
+	tag, parsed.Directives = SplitDirectives(tag)
 
 	// Handle empty string
 	if tag == "" {
@@ -74,3 +78,78 @@ func ParseGraphQLTag(tag string) (ParsedTag, error) {
 
 	return parsed, nil
 }
+
+// SplitDirectives splits the directives from a GraphQL struct tag value.
+// Directives start at the first "@" outside of arguments and strings.
+// Examples:
+//   - "name @include(if: $x)" -> "name", ["@include(if: $x)"]
+//   - "user(id: $id) @skip(if: $a) @foo" -> "user(id: $id)", ["@skip(if: $a)", "@foo"]
+func SplitDirectives(tag string) (string, []string) {
+	var directives []string
+	head := ""
+	start := -1
+	depth := 0
+	inString := false
+
+	for i := 0; i < len(tag); i++ {
+		switch c := tag[i]; {
+		case c == '\\':
+			// skip the escaped character
+			i++
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == '@' && depth == 0:
+			if start == -1 {
+				head = tag[:i]
+			} else {
+				directives = append(directives, strings.TrimSpace(tag[start:i]))
+			}
+			start = i
+		}
+	}
+
+	if start == -1 {
+		return tag, nil
+	}
+	directives = append(directives, strings.TrimSpace(tag[start:]))
+	return strings.TrimSpace(head), directives
+}
+
+// VariableReferences returns the names of the variables referenced by tag, in order of appearance.
+// Dollar signs inside strings aren't references.
+// Example: `user(id: $id, filter: "$x") @include(if: $withUser)` -> ["id", "withUser"]
+func VariableReferences(tag string) []string {
+	var names []string
+	inString := false
+
+	for i := 0; i < len(tag); i++ {
+		switch c := tag[i]; {
+		case c == '\\':
+			// skip the escaped character
+			i++
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '$':
+			end := i + 1
+			for end < len(tag) && isNameChar(tag[end], end == i+1) {
+				end++
+			}
+			if end > i+1 {
+				names = append(names, tag[i+1:end])
+			}
+			i = end - 1
+		}
+	}
+	return names
+}
+
+// isNameChar reports whether c is allowed in a GraphQL name, digits aren't allowed first
+func isNameChar(c byte, first bool) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || !first && c >= '0' && c <= '9'
+}
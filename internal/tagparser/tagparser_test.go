@@ -1,6 +1,7 @@
 package tagparser
 
 import (
+	"reflect"
 	"testing"
 )
 
@@ -343,3 +344,71 @@ func TestParseGraphQLTag_LongFragmentTypename(t *testing.T) {
 		t.Errorf("expected TypeName 'SolanaTokenTransferAuthorizationRequest', got '%s'", parsed.TypeName)
 	}
 }
+
+func TestParseGraphQLTag_Directives(t *testing.T) {
+	tests := []struct {
+		tag            string
+		wantAlias      string
+		wantFieldName  string
+		wantArguments  string
+		wantTypeName   string
+		wantDirectives []string
+	}{
+		{
+			tag:            "name @include(if: $withName)",
+			wantFieldName:  "name",
+			wantDirectives: []string{"@include(if: $withName)"},
+		},
+		{
+			tag:            "me: user(id: $id, filter: \"a@b\") @skip(if: $anonymous) @cached",
+			wantAlias:      "me",
+			wantFieldName:  "user",
+			wantArguments:  "id: $id, filter: \"a@b\"",
+			wantDirectives: []string{"@skip(if: $anonymous)", "@cached"},
+		},
+		{
+			tag:            "... on Droid @include(if: $withDroids)",
+			wantTypeName:   "Droid",
+			wantDirectives: []string{"@include(if: $withDroids)"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.tag, func(t *testing.T) {
+			parsed, err := ParseGraphQLTag(tt.tag)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if parsed.Alias != tt.wantAlias || parsed.FieldName != tt.wantFieldName ||
+				parsed.Arguments != tt.wantArguments || parsed.TypeName != tt.wantTypeName {
+				t.Errorf("got %+v", parsed)
+			}
+			if !reflect.DeepEqual(parsed.Directives, tt.wantDirectives) {
+				t.Errorf("expected Directives %q, got %q", tt.wantDirectives, parsed.Directives)
+			}
+		})
+	}
+}
+
+func TestVariableReferences(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want []string
+	}{
+		{tag: "name", want: nil},
+		{tag: "user(id: $id)", want: []string{"id"}},
+		{
+			tag:  `me: user(id: $id, filter: "$notAVariable \" $still") @include(if: $with_user2)`,
+			want: []string{"id", "with_user2"},
+		},
+		{tag: "users(ids: [$a, $b], first: $1)", want: []string{"a", "b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.tag, func(t *testing.T) {
+			if got := VariableReferences(tt.tag); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("VariableReferences() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
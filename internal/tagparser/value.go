@@ -0,0 +1,244 @@
+package tagparser
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ValidateConstValue returns an error if value isn't a GraphQL constant value,
+// like the default values of variables. Variables aren't allowed in constant values.
+// Examples:
+//   - `10`, `-1.5e3`, `"text"`, `"""block"""`, `true`, `null`, `ASC`
+//   - `[1, 2]`, `{name: "Go*", tags: [A, B]}`
+func ValidateConstValue(value string) error {
+	p := &valueParser{s: value}
+	p.skipIgnored()
+	if p.eof() {
+		return errors.New("empty value")
+	}
+	if err := p.parseValue(); err != nil {
+		return err
+	}
+	p.skipIgnored()
+	if !p.eof() {
+		return fmt.Errorf("unexpected %q after the value", p.s[p.i:])
+	}
+	return nil
+}
+
+// valueParser parses the GraphQL value s from the offset i
+type valueParser struct {
+	s string
+	i int
+}
+
+func (p *valueParser) eof() bool {
+	return p.i >= len(p.s)
+}
+
+// skipIgnored skips the whitespaces, line terminators and commas, which are insignificant in GraphQL
+func (p *valueParser) skipIgnored() {
+	for !p.eof() {
+		switch p.s[p.i] {
+		case ' ', '\t', '\n', '\r', ',':
+			p.i++
+		default:
+			return
+		}
+	}
+}
+
+func (p *valueParser) parseValue() error {
+	if p.eof() {
+		return errors.New("unexpected end of the value")
+	}
+	switch c := p.s[p.i]; {
+	case c == '$':
+		return errors.New("variables aren't allowed in constant values")
+	case c == '"':
+		return p.parseString()
+	case c == '[':
+		return p.parseList()
+	case c == '{':
+		return p.parseObject()
+	case c == '-' || c >= '0' && c <= '9':
+		return p.parseNumber()
+	case isNameChar(c, true):
+		// booleans, null and enum values
+		p.parseName()
+		return nil
+	default:
+		return fmt.Errorf("unexpected character %q", c)
+	}
+}
+
+func (p *valueParser) parseName() string {
+	start := p.i
+	for !p.eof() && isNameChar(p.s[p.i], p.i == start) {
+		p.i++
+	}
+	return p.s[start:p.i]
+}
+
+func (p *valueParser) parseList() error {
+	p.i++
+	for {
+		p.skipIgnored()
+		if p.eof() {
+			return errors.New("unterminated list")
+		}
+		if p.s[p.i] == ']' {
+			p.i++
+			return nil
+		}
+		if err := p.parseValue(); err != nil {
+			return err
+		}
+	}
+}
+
+func (p *valueParser) parseObject() error {
+	p.i++
+	for {
+		p.skipIgnored()
+		if p.eof() {
+			return errors.New("unterminated object")
+		}
+		if p.s[p.i] == '}' {
+			p.i++
+			return nil
+		}
+		name := p.parseName()
+		if name == "" {
+			return fmt.Errorf("unexpected character %q, want an object field name", p.s[p.i])
+		}
+		p.skipIgnored()
+		if p.eof() || p.s[p.i] != ':' {
+			return fmt.Errorf("missing colon after the object field %s", name)
+		}
+		p.i++
+		p.skipIgnored()
+		if err := p.parseValue(); err != nil {
+			return err
+		}
+	}
+}
+
+// parseNumber parses an integer or a float. A number can't be followed by a name or a dot
+func (p *valueParser) parseNumber() error {
+	start := p.i
+	if p.s[p.i] == '-' {
+		p.i++
+	}
+	if p.eof() || !isDigit(p.s[p.i]) {
+		return fmt.Errorf("invalid number %q", p.s[start:p.i])
+	}
+	if p.s[p.i] == '0' {
+		p.i++
+	} else {
+		p.skipDigits()
+	}
+	if !p.eof() && p.s[p.i] == '.' {
+		p.i++
+		if p.skipDigits() == 0 {
+			return fmt.Errorf("invalid number %q", p.s[start:p.i])
+		}
+	}
+	if !p.eof() && (p.s[p.i] == 'e' || p.s[p.i] == 'E') {
+		p.i++
+		if !p.eof() && (p.s[p.i] == '+' || p.s[p.i] == '-') {
+			p.i++
+		}
+		if p.skipDigits() == 0 {
+			return fmt.Errorf("invalid number %q", p.s[start:p.i])
+		}
+	}
+	if !p.eof() && (p.s[p.i] == '.' || isNameChar(p.s[p.i], false)) {
+		return fmt.Errorf("invalid number %q", p.s[start:p.i+1])
+	}
+	return nil
+}
+
+// skipDigits skips the digits and returns their count
+func (p *valueParser) skipDigits() int {
+	start := p.i
+	for !p.eof() && isDigit(p.s[p.i]) {
+		p.i++
+	}
+	return p.i - start
+}
+
+func (p *valueParser) parseString() error {
+	if strings.HasPrefix(p.s[p.i:], `"""`) {
+		return p.parseBlockString()
+	}
+	p.i++
+	for !p.eof() {
+		switch c := p.s[p.i]; c {
+		case '"':
+			p.i++
+			return nil
+		case '\n', '\r':
+			return errors.New("unterminated string")
+		case '\\':
+			if err := p.parseEscape(); err != nil {
+				return err
+			}
+		default:
+			p.i++
+		}
+	}
+	return errors.New("unterminated string")
+}
+
+// parseEscape parses the escaped character of a string, after the backslash
+func (p *valueParser) parseEscape() error {
+	p.i++
+	if p.eof() {
+		return errors.New("unterminated string")
+	}
+	switch p.s[p.i] {
+	case '"', '\\', '/', 'b', 'f', 'n', 'r', 't':
+		p.i++
+		return nil
+	case 'u':
+		if p.i+5 > len(p.s) || !isHex(p.s[p.i+1:p.i+5]) {
+			return errors.New("invalid unicode escape sequence in string")
+		}
+		p.i += 5
+		return nil
+	default:
+		return fmt.Errorf("invalid escape sequence \\%c in string", p.s[p.i])
+	}
+}
+
+// parseBlockString parses a block string, whose only escape sequence is \"""
+func (p *valueParser) parseBlockString() error {
+	p.i += 3
+	for !p.eof() {
+		switch {
+		case strings.HasPrefix(p.s[p.i:], `\"""`):
+			p.i += 4
+		case strings.HasPrefix(p.s[p.i:], `"""`):
+			p.i += 3
+			return nil
+		default:
+			p.i++
+		}
+	}
+	return errors.New("unterminated block string")
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isDigit(s[i]) && (s[i] < 'a' || s[i] > 'f') && (s[i] < 'A' || s[i] > 'F') {
+			return false
+		}
+	}
+	return true
+}
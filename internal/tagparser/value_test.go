@@ -0,0 +1,56 @@
+package tagparser
+
+import "testing"
+
+func TestValidateConstValue(t *testing.T) {
+	tests := []struct {
+		value   string
+		wantErr string
+	}{
+		{value: "10"},
+		{value: "-0"},
+		{value: "-1.5e3"},
+		{value: "2E+10"},
+		{value: `"text with \"quotes\" and é"`},
+		{value: `"""block "string" \""" end"""`},
+		{value: "true"},
+		{value: "null"},
+		{value: "CREATED_AT"},
+		{value: " [1, 2 3] "},
+		{value: `{name: "Go*", tags: [A, B], nested: {active: true}}`},
+		{value: "[]"},
+		{value: "", wantErr: "empty value"},
+		{value: "01", wantErr: `invalid number "01"`},
+		{value: "1.", wantErr: `invalid number "1."`},
+		{value: "1.5e", wantErr: `invalid number "1.5e"`},
+		{value: "10px", wantErr: `invalid number "10p"`},
+		{value: "-", wantErr: `invalid number "-"`},
+		{value: `"open`, wantErr: "unterminated string"},
+		{value: `"""open`, wantErr: "unterminated block string"},
+		{value: `"\x"`, wantErr: `invalid escape sequence \x in string`},
+		{value: `"\u12"`, wantErr: "invalid unicode escape sequence in string"},
+		{value: "[1, 2", wantErr: "unterminated list"},
+		{value: "{a: 1", wantErr: "unterminated object"},
+		{value: "{a 1}", wantErr: "missing colon after the object field a"},
+		{value: "{1: 1}", wantErr: `unexpected character '1', want an object field name`},
+		{value: "$first", wantErr: "variables aren't allowed in constant values"},
+		{value: "[$first]", wantErr: "variables aren't allowed in constant values"},
+		{value: "ASC DESC", wantErr: `unexpected "DESC" after the value`},
+		{value: "'a'", wantErr: `unexpected character '\''`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			err := ValidateConstValue(tt.value)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("ValidateConstValue() error = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("ValidateConstValue() error = %v, want %s", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package graphql
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// SubscriptionState represents the state of a subscription of SubscriptionClient
+type SubscriptionState int
+
+const (
+	// SubscriptionPending is the state of a subscription which isn't started on the current connection,
+	// because the client isn't connected yet or is reconnecting
+	SubscriptionPending SubscriptionState = iota
+	// SubscriptionStarted is the state of a subscription whose start message was sent on the current connection
+	SubscriptionStarted
+	// SubscriptionFailed is the state of a subscription which the server ended with an error.
+	// It isn't started again, and is listed until it's unsubscribed
+	SubscriptionFailed
+)
+
+func (ss SubscriptionState) String() string {
+	switch ss {
+	case SubscriptionPending:
+		return "pending"
+	case SubscriptionStarted:
+		return "started"
+	case SubscriptionFailed:
+		return "failed"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(ss))
+	}
+}
+
+// SubscriptionInfo is a snapshot of a subscription of SubscriptionClient.
+// The counters and timestamps of deduplicated subscriptions are the ones of their shared operation
+type SubscriptionInfo struct {
+	ID            string
+	OperationName string
+	Query         string
+	Variables     map[string]any
+	State         SubscriptionState
+	// Messages is the number of results received, including errors
+	Messages int
+	// Errors is the number of results with errors
+	Errors int
+	// CreatedAt is the time the subscription was added to the client
+	CreatedAt time.Time
+	// StartedAt is the time the subscription was last started, zero if it was never started
+	StartedAt time.Time
+	// LastMessageAt is the time the last result was received, zero if no result was received
+	LastMessageAt time.Time
+}
+
+// subscriptionStats are the counters and timestamps of a subscription, guarded by the client mutex
+type subscriptionStats struct {
+	createdAt     time.Time
+	startedAt     time.Time
+	lastMessageAt time.Time
+	messages      int
+	errors        int
+}
+
+var operationNameRegexp = regexp.MustCompile(`^\s*(?:subscription|query|mutation)\s+([_A-Za-z][_0-9A-Za-z]*)`)
+
+// operationName returns the name of the operation of query, or an empty string if it's anonymous
+func operationName(query string) string {
+	if m := operationNameRegexp.FindStringSubmatch(query); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// Subscriptions returns a snapshot of the subscriptions and live queries of the client, sorted by creation time.
+// Queries and mutations sent with Query and Mutate aren't listed
+func (sc *SubscriptionClient) Subscriptions() []SubscriptionInfo {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	infos := make([]SubscriptionInfo, 0, len(sc.subscriptions))
+	for id, sub := range sc.subscriptions {
+		switch {
+		case sub.done != nil:
+		case sub.shared != nil:
+			for sharedID, subscriber := range sub.shared.subscribers {
+				info := sub.info(sharedID)
+				info.CreatedAt = subscriber.stats.createdAt
+				infos = append(infos, info)
+			}
+		default:
+			infos = append(infos, sub.info(id))
+		}
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		if !infos[i].CreatedAt.Equal(infos[j].CreatedAt) {
+			return infos[i].CreatedAt.Before(infos[j].CreatedAt)
+		}
+		return infos[i].ID < infos[j].ID
+	})
+	return infos
+}
+
+// info returns the snapshot of the subscription. The caller must hold the client mutex
+func (sub *subscription) info(id string) SubscriptionInfo {
+	state := SubscriptionPending
+	switch {
+	case sub.failed:
+		state = SubscriptionFailed
+	case sub.started:
+		state = SubscriptionStarted
+	}
+
+	return SubscriptionInfo{
+		ID:            id,
+		OperationName: operationName(sub.query),
+		Query:         sub.query,
+		Variables:     maps.Clone(sub.variables),
+		State:         state,
+		Messages:      sub.stats.messages,
+		Errors:        sub.stats.errors,
+		CreatedAt:     sub.stats.createdAt,
+		StartedAt:     sub.stats.startedAt,
+		LastMessageAt: sub.stats.lastMessageAt,
+	}
+}
+
+// recordMessage counts a result received by the subscription
+func (sc *SubscriptionClient) recordMessage(sub *subscription, failed bool) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	sub.stats.messages++
+	if failed {
+		sub.stats.errors++
+	}
+	sub.stats.lastMessageAt = time.Now()
+}
@@ -0,0 +1,68 @@
+package graphql
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSubscriptionClient_Subscriptions(t *testing.T) {
+	_, wsURL := newTestWebsocketServer(t, serveTestSubscriptions)
+
+	client := NewSubscriptionClient(wsURL).
+		WithTimeout(5 * time.Second)
+	defer func() { _ = client.Close() }()
+
+	messages := make(chan []byte, 1)
+	query := `subscription Test($id: ID!) { test(id: $id) }`
+	variables := map[string]any{"id": "1"}
+	id, err := client.Exec(query, variables, func(message []byte, err error) error {
+		messages <- message
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("got error: %v, want: nil", err)
+	}
+
+	infos := client.Subscriptions()
+	if len(infos) != 1 {
+		t.Fatalf("got %d subscriptions, want: 1", len(infos))
+	}
+	info := infos[0]
+	if info.ID != id || info.OperationName != "Test" || info.Query != query ||
+		!reflect.DeepEqual(info.Variables, variables) {
+		t.Errorf("got subscription: %+v, want: %s Test", info, id)
+	}
+	if info.State != SubscriptionPending || !info.StartedAt.IsZero() || info.CreatedAt.IsZero() {
+		t.Errorf("got state: %s, started at: %s, created at: %s, want: pending", info.State, info.StartedAt, info.CreatedAt)
+	}
+
+	go func() { _ = client.Run() }()
+	select {
+	case <-messages:
+	case <-time.After(5 * time.Second):
+		t.Fatal("message wasn't received")
+	}
+
+	info = client.Subscriptions()[0]
+	if info.State != SubscriptionStarted || info.StartedAt.IsZero() {
+		t.Errorf("got state: %s, started at: %s, want: started", info.State, info.StartedAt)
+	}
+	if info.Messages != 1 || info.Errors != 0 || info.LastMessageAt.IsZero() {
+		t.Errorf("got messages: %d, errors: %d, last message at: %s, want: 1 message", info.Messages, info.Errors, info.LastMessageAt)
+	}
+}
+
+func TestOperationName(t *testing.T) {
+	tests := map[string]string{
+		`subscription Test($id: ID!) { test(id: $id) }`: "Test",
+		`subscription{test}`:                            "",
+		`query  Get_1 { test }`:                         "Get_1",
+		`{ test }`:                                      "",
+	}
+	for query, want := range tests {
+		if got := operationName(query); got != want {
+			t.Errorf("got operation name of %s: %q, want: %q", query, got, want)
+		}
+	}
+}
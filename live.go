@@ -0,0 +1,210 @@
+package graphql
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/llehouerou/go-graphql-client/internal/jsonpatch"
+)
+
+// Live queries follow the GraphQL Live Query JSON Patch format
+// https://github.com/n1ru4l/graphql-live-query/tree/main/packages/graphql-live-query-patch-json-patch
+// The server sends the initial result with data, then results with a JSON Patch (RFC 6902) to apply to the data.
+
+// liveDirective is the operation directive of live queries
+type liveDirective struct{}
+
+func (liveDirective) Type() OptionType {
+	return OptionTypeOperationDirective
+}
+
+func (liveDirective) String() string {
+	return "@live"
+}
+
+// liveState keeps the latest result of a live query
+type liveState struct {
+	// data, revision and seq are guarded by the client mutex
+	data     any
+	revision int64
+	seq      uint64
+
+	// mu serializes the handler calls. delivered is the sequence number of the latest delivered result
+	mu        sync.Mutex
+	delivered uint64
+}
+
+// reset drops the result, the server sends the initial result again when the query is restarted
+func (ls *liveState) reset() {
+	ls.data = nil
+	ls.revision = 0
+}
+
+// LiveQuery starts a live query derived from q, adding the @live directive to the query.
+// The client keeps the latest result, applies the patches sent by the server,
+// and calls the handler with the full data of every new result.
+//
+// Results are delivered in order, but if the handler is slower than the updates,
+// outdated results may be skipped, so that the handler is called with the latest result
+func (sc *SubscriptionClient) LiveQuery(
+	q any,
+	variables map[string]any,
+	handler func(message []byte, err error) error,
+	options ...Option,
+) (string, error) {
+	query, omitted, err := constructOperation(
+		"query",
+		q,
+		variables,
+		false,
+		sc.scalars,
+		append(options, liveDirective{})...,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	return sc.execLive(query, omitVariableMap(variables, omitted), handler, options...)
+}
+
+// ExecLive starts a live query with a pre-built query, which must have the @live directive
+func (sc *SubscriptionClient) ExecLive(
+	query string,
+	variables map[string]any,
+	handler func(message []byte, err error) error,
+) (string, error) {
+	return sc.execLive(query, variables, handler)
+}
+
+func (sc *SubscriptionClient) execLive(
+	query string,
+	variables map[string]any,
+	handler func(message []byte, err error) error,
+	options ...Option,
+) (string, error) {
+	variables, err := sc.scalars.encodeVariableMap(variables)
+	if err != nil {
+		return "", err
+	}
+	id := uuid.New().String()
+
+	sub := subscription{
+		query:     query,
+		variables: variables,
+		live:      &liveState{},
+		stats:     subscriptionStats{createdAt: time.Now()},
+	}
+	applySubscriptionOptions(&sub, options)
+	sub.handler = sc.wrapHandler(id, sub.errorPolicy, handler)
+
+	if err := sc.addSubscription(id, &sub); err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// handleLiveDataMessage updates the result of the live query with the payload, then calls the handler with the full data
+func (sc *SubscriptionClient) handleLiveDataMessage(sub *subscription, payload json.RawMessage) {
+	var out struct {
+		Data     *json.RawMessage
+		Errors   Errors
+		Patch    []jsonpatch.Operation
+		Revision *int64
+	}
+
+	err := json.Unmarshal(payload, &out)
+	sc.recordMessage(sub, err != nil || len(out.Errors) > 0)
+	if err != nil {
+		sc.dispatch(sub, nil, err)
+		return
+	}
+	if len(out.Errors) > 0 {
+		sc.dispatch(sub, nil, out.Errors)
+		return
+	}
+
+	sc.mu.Lock()
+	data, seq, err := sub.live.update(out.Data, out.Patch, out.Revision)
+	sc.mu.Unlock()
+	if err != nil {
+		sc.dispatch(sub, nil, err)
+		return
+	}
+	if data != nil {
+		sc.dispatchLive(sub, data, seq)
+	}
+}
+
+// update applies the payload to the result, and returns the full data with its sequence number.
+// It returns nil data if the payload doesn't change the result. The client mutex must be held
+func (ls *liveState) update(
+	payload *json.RawMessage,
+	patch []jsonpatch.Operation,
+	revision *int64,
+) ([]byte, uint64, error) {
+	switch {
+	case payload != nil:
+		data, err := jsonpatch.Decode(*payload)
+		if err != nil {
+			return nil, 0, err
+		}
+		ls.data = data
+	case patch != nil:
+		if ls.data == nil {
+			return nil, 0, errors.New("live query patch received before the initial result")
+		}
+		if revision != nil && ls.revision != 0 && *revision != ls.revision+1 {
+			err := fmt.Errorf(
+				"live query revision %d doesn't follow revision %d",
+				*revision,
+				ls.revision,
+			)
+			// the result can't be trusted anymore, until the server sends a full result
+			ls.reset()
+			return nil, 0, err
+		}
+
+		data, err := jsonpatch.Apply(ls.data, patch)
+		if err != nil {
+			ls.reset()
+			return nil, 0, fmt.Errorf("live query patch: %w", err)
+		}
+		ls.data = data
+	default:
+		return nil, 0, nil
+	}
+
+	if revision != nil {
+		ls.revision = *revision
+	}
+
+	data, err := json.Marshal(ls.data)
+	if err != nil {
+		return nil, 0, err
+	}
+	ls.seq++
+	return data, ls.seq, nil
+}
+
+// dispatchLive calls the handler of the live query in a new goroutine, unless a newer result was delivered first
+func (sc *SubscriptionClient) dispatchLive(sub *subscription, data []byte, seq uint64) {
+	live := sub.live
+
+	sc.handlersWg.Add(1)
+	go func() {
+		defer sc.handlersWg.Done()
+
+		live.mu.Lock()
+		defer live.mu.Unlock()
+		if seq < live.delivered {
+			return
+		}
+		live.delivered = seq
+		sub.handler(data, nil)
+	}()
+}
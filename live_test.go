@@ -0,0 +1,164 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/llehouerou/go-graphql-client/internal/jsonpatch"
+)
+
+func TestSubscriptionClient_LiveQuery(t *testing.T) {
+	queries := make(chan string, 1)
+	_, wsURL := newTestWebsocketServer(t, func(c *websocket.Conn) {
+		for {
+			var msg OperationMessage
+			if err := c.ReadJSON(&msg); err != nil {
+				return
+			}
+			switch msg.Type {
+			case GQL_CONNECTION_INIT:
+				_ = c.WriteJSON(map[string]string{"type": string(GQL_CONNECTION_ACK)})
+			case GQL_START:
+				var payload struct {
+					Query string
+				}
+				_ = json.Unmarshal(msg.Payload, &payload)
+				queries <- payload.Query
+
+				for _, result := range []string{
+					`{"data":{"user":{"name":"a","tags":[]}},"revision":1}`,
+					`{"patch":[{"op":"replace","path":"/user/name","value":"b"}],"revision":2}`,
+					`{"patch":[{"op":"add","path":"/user/tags/-","value":"x"}],"revision":3}`,
+					`{"patch":[{"op":"add","path":"/user/tags/-","value":"y"}],"revision":5}`,
+				} {
+					_ = c.WriteJSON(map[string]any{
+						"id":      msg.ID,
+						"type":    string(GQL_DATA),
+						"payload": json.RawMessage(result),
+					})
+				}
+			case GQL_CONNECTION_TERMINATE:
+				return
+			}
+		}
+	})
+
+	client := NewSubscriptionClient(wsURL).
+		WithTimeout(5 * time.Second).
+		OnError(func(sc *SubscriptionClient, err error) error {
+			return nil
+		})
+
+	var q struct {
+		User struct {
+			Name string
+			Tags []string
+		} `graphql:"user(id: $id)"`
+	}
+
+	var mu sync.Mutex
+	var messages []string
+	errs := make(chan error, 1)
+	_, err := client.LiveQuery(&q, map[string]any{"id": ID("1")}, func(message []byte, err error) error {
+		if err != nil {
+			errs <- err
+			return nil
+		}
+		mu.Lock()
+		messages = append(messages, string(message))
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("got error: %v, want: nil", err)
+	}
+
+	go func() { _ = client.Run() }()
+	defer func() { _ = client.Close() }()
+
+	select {
+	case query := <-queries:
+		if want := "query ($id:ID!) @live {user(id: $id){name,tags}}"; query != want {
+			t.Errorf("got query: %s, want: %s", query, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the live query wasn't started")
+	}
+
+	// the revision 4 is missing
+	select {
+	case err := <-errs:
+		if !strings.Contains(err.Error(), "revision 5 doesn't follow revision 3") {
+			t.Errorf("got error: %v, want revision error", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the revision error wasn't received")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Shutdown(ctx); err != nil {
+		t.Fatalf("got error: %v, want: nil", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(messages) == 0 {
+		t.Fatal("no result was received")
+	}
+	// intermediate results may be skipped, the last one is always delivered
+	if got, want := messages[len(messages)-1], `{"user":{"name":"b","tags":["x"]}}`; got != want {
+		t.Errorf("got last result: %s, want: %s", got, want)
+	}
+}
+
+func TestLiveState_Update(t *testing.T) {
+	decodePatch := func(patch string) []jsonpatch.Operation {
+		var ops []jsonpatch.Operation
+		if err := json.Unmarshal([]byte(patch), &ops); err != nil {
+			t.Fatalf("got error: %v, want: nil", err)
+		}
+		return ops
+	}
+	ls := &liveState{}
+
+	if _, _, err := ls.update(nil, decodePatch(`[{"op":"add","path":"/a","value":1}]`), nil); err == nil {
+		t.Error("expected error when the patch is received before the initial result")
+	}
+
+	initial := json.RawMessage(`{"a":{"b":1.50}}`)
+	data, seq, err := ls.update(&initial, nil, nil)
+	if err != nil {
+		t.Fatalf("got error: %v, want: nil", err)
+	}
+	if string(data) != `{"a":{"b":1.50}}` || seq != 1 {
+		t.Errorf("got data: %s, seq: %d, want: {\"a\":{\"b\":1.50}}, 1", data, seq)
+	}
+
+	data, seq, err = ls.update(nil, decodePatch(`[{"op":"add","path":"/a/c","value":[true]}]`), nil)
+	if err != nil {
+		t.Fatalf("got error: %v, want: nil", err)
+	}
+	if string(data) != `{"a":{"b":1.50,"c":[true]}}` || seq != 2 {
+		t.Errorf("got data: %s, seq: %d, want: {\"a\":{\"b\":1.50,\"c\":[true]}}, 2", data, seq)
+	}
+
+	// a failed patch drops the result
+	if _, _, err := ls.update(nil, decodePatch(`[{"op":"remove","path":"/missing"}]`), nil); err == nil {
+		t.Error("expected error when the patch fails")
+	}
+	if _, _, err := ls.update(nil, decodePatch(`[{"op":"remove","path":"/a"}]`), nil); err == nil {
+		t.Error("expected error when the patch is received after a failed patch")
+	}
+
+	// a payload without data nor patch doesn't change the result
+	data, _, err = ls.update(nil, nil, nil)
+	if err != nil || data != nil {
+		t.Errorf("got data: %s, error: %v, want: nil, nil", data, err)
+	}
+}
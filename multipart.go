@@ -0,0 +1,395 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// Multipart transport follows Apollo's multipart HTTP protocol for subscriptions
+// https://www.apollographql.com/docs/router/executing-operations/subscription-multipart-protocol
+
+// MultipartAccept is the Accept header of multipart subscription requests
+const MultipartAccept = `multipart/mixed;subscriptionSpec="1.0", application/json`
+
+type multipartSubscription struct {
+	handler func(data []byte, err error) error
+	cancel  context.CancelFunc
+}
+
+// MultipartClient is a GraphQL subscription client consuming multipart/mixed HTTP responses,
+// as served by Apollo Router. Every subscription is a POST request whose response streams one part per result.
+//
+// Requests are sent with the http.Client, the request modifier and the debug mode of the Client.
+// The http.Client shouldn't have a timeout, because it would close long running subscriptions.
+//
+// Subscriptions start when Subscribe is called, there is no Run method.
+// The On* methods configure the client and must be called before Subscribe.
+// Other methods are safe for concurrent use.
+type MultipartClient struct {
+	client  *Client
+	onError func(mc *MultipartClient, err error)
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu            sync.Mutex
+	subscriptions map[string]*multipartSubscription
+	closed        bool
+}
+
+// NewMultipartClient creates a multipart subscription client, sending requests to the URL of client
+func NewMultipartClient(client *Client) *MultipartClient {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &MultipartClient{
+		client:        client,
+		ctx:           ctx,
+		cancel:        cancel,
+		subscriptions: make(map[string]*multipartSubscription),
+	}
+}
+
+// OnError event is triggered when a subscription handler returns an error
+func (mc *MultipartClient) OnError(onError func(mc *MultipartClient, err error)) *MultipartClient {
+	mc.onError = onError
+	return mc
+}
+
+// Subscribe starts a subscription derived from v.
+// The handler receives the data of every result, or the error of the subscription.
+// Returning a non-nil error from the handler stops the subscription. Unless it's ErrSubscriptionStopped,
+// the error is also reported to the OnError event
+func (mc *MultipartClient) Subscribe(
+	v any,
+	variables map[string]any,
+	handler func(message []byte, err error) error,
+	options ...Option,
+) (string, error) {
+	return mc.SubscribeWithContext(context.Background(), v, variables, handler, options...)
+}
+
+// SubscribeWithContext starts a subscription derived from v, which is stopped when ctx is done
+func (mc *MultipartClient) SubscribeWithContext(
+	ctx context.Context,
+	v any,
+	variables map[string]any,
+	handler func(message []byte, err error) error,
+	options ...Option,
+) (string, error) {
+	query, omitted, err := constructOperation(
+		"subscription",
+		v,
+		variables,
+		true,
+		mc.client.scalars,
+		options...,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	return mc.ExecWithContext(ctx, query, omitVariableMap(variables, omitted), handler)
+}
+
+// Exec starts a subscription with a pre-built query
+func (mc *MultipartClient) Exec(
+	query string,
+	variables map[string]any,
+	handler func(message []byte, err error) error,
+) (string, error) {
+	return mc.ExecWithContext(context.Background(), query, variables, handler)
+}
+
+// ExecWithContext starts a subscription with a pre-built query, which is stopped when ctx is done
+func (mc *MultipartClient) ExecWithContext(
+	ctx context.Context,
+	query string,
+	variables map[string]any,
+	handler func(message []byte, err error) error,
+) (string, error) {
+	variables, err := mc.client.scalars.encodeVariableMap(variables)
+	if err != nil {
+		return "", err
+	}
+	id := uuid.New().String()
+	subCtx, cancel := context.WithCancel(ctx)
+	// the subscription is also stopped by Close
+	stop := context.AfterFunc(mc.ctx, cancel)
+	sub := &multipartSubscription{
+		handler: handler,
+		cancel: func() {
+			stop()
+			cancel()
+		},
+	}
+
+	mc.mu.Lock()
+	if mc.closed {
+		mc.mu.Unlock()
+		sub.cancel()
+		return "", errors.New("multipart client is closed")
+	}
+	mc.subscriptions[id] = sub
+	mc.mu.Unlock()
+
+	go mc.run(subCtx, id, sub, query, variables)
+
+	return id, nil
+}
+
+// Unsubscribe stops the subscription and closes its connection
+func (mc *MultipartClient) Unsubscribe(id string) error {
+	if mc.remove(id) == nil {
+		return fmt.Errorf("subscription id %s doesn't not exist", id)
+	}
+	return nil
+}
+
+// Close stops all subscriptions and closes their connections
+func (mc *MultipartClient) Close() error {
+	mc.mu.Lock()
+	mc.closed = true
+	for id, sub := range mc.subscriptions {
+		sub.cancel()
+		delete(mc.subscriptions, id)
+	}
+	mc.mu.Unlock()
+
+	mc.cancel()
+	return nil
+}
+
+// remove deletes the subscription and cancels its context. It returns nil if the subscription doesn't exist
+func (mc *MultipartClient) remove(id string) *multipartSubscription {
+	mc.mu.Lock()
+	sub, ok := mc.subscriptions[id]
+	delete(mc.subscriptions, id)
+	mc.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+	sub.cancel()
+	return sub
+}
+
+// handle calls the subscription handler.
+// It returns false if the handler stopped the subscription
+func (mc *MultipartClient) handle(id string, sub *multipartSubscription, data []byte, err error) bool {
+	errValue := sub.handler(data, err)
+	if errValue == nil {
+		return true
+	}
+
+	mc.remove(id)
+	if !errors.Is(errValue, ErrSubscriptionStopped) && mc.onError != nil {
+		mc.onError(mc, errValue)
+	}
+	return false
+}
+
+// handleResult decodes the execution result and calls the subscription handler
+func (mc *MultipartClient) handleResult(id string, sub *multipartSubscription, payload []byte) bool {
+	data, errs := mc.client.DecodeResponse(bytes.NewReader(payload))
+	if len(errs) > 0 {
+		return mc.handle(id, sub, nil, errs)
+	}
+	return mc.handle(id, sub, data, nil)
+}
+
+// multipartPart is the JSON body of a part. Heartbeats are empty objects,
+// transport errors have top-level errors and a null payload
+type multipartPart struct {
+	Payload json.RawMessage `json:"payload"`
+	Errors  Errors          `json:"errors"`
+}
+
+// run sends the subscription request and reads the parts of the response, until the stream is terminated
+func (mc *MultipartClient) run(
+	ctx context.Context,
+	id string,
+	sub *multipartSubscription,
+	query string,
+	variables map[string]any,
+) {
+	defer mc.remove(id)
+
+	header := http.Header{"Accept": []string{MultipartAccept}}
+	req, reqBody, err := mc.client.newStreamRequest(ctx, http.MethodPost, header, streamRequest{
+		Query:     query,
+		Variables: variables,
+	})
+	if err != nil {
+		mc.handle(id, sub, nil, Errors{mc.client.NewRequestError(
+			ErrRequestError,
+			fmt.Errorf("problem constructing request: %w", err),
+			req,
+			nil,
+			bytes.NewReader(reqBody),
+			nil,
+		)})
+		return
+	}
+
+	resp, err := mc.client.httpClient.Do(req)
+	if err != nil {
+		if ctx.Err() == nil {
+			mc.handle(id, sub, nil, Errors{mc.client.NewRequestError(
+				ErrRequestError,
+				err,
+				req,
+				nil,
+				bytes.NewReader(reqBody),
+				nil,
+			)})
+		}
+		return
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if errs := mc.client.checkStreamResponse(resp, http.StatusOK, req, reqBody); len(errs) > 0 {
+		mc.handle(id, sub, nil, errs)
+		return
+	}
+
+	mediaType, params, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	// the server may execute the operation as a single result instead of a stream
+	if mediaType != "multipart/mixed" {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			mc.handle(id, sub, nil, newSimpleErrors(ErrJsonDecode, err))
+			return
+		}
+		mc.handleResult(id, sub, body)
+		return
+	}
+
+	boundary := params["boundary"]
+	if boundary == "" {
+		boundary = "-"
+	}
+	parts := newMultipartReader(resp.Body, boundary)
+	for {
+		body, err := parts.next()
+		if errors.Is(err, io.EOF) {
+			return
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				mc.handle(id, sub, nil, Errors{mc.client.NewRequestError(
+					ErrRequestError,
+					fmt.Errorf("multipart stream closed: %w", err),
+					req,
+					resp,
+					bytes.NewReader(reqBody),
+					nil,
+				)})
+			}
+			return
+		}
+
+		if !mc.handlePart(id, sub, body) {
+			return
+		}
+	}
+}
+
+// handlePart dispatches the body of a part to the subscription handler.
+// It returns false if the subscription is stopped
+func (mc *MultipartClient) handlePart(id string, sub *multipartSubscription, body []byte) bool {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return true
+	}
+
+	var part multipartPart
+	if err := json.Unmarshal(body, &part); err != nil {
+		return mc.handle(id, sub, nil, newSimpleErrors(ErrJsonDecode, err))
+	}
+
+	// transport errors terminate the subscription
+	if len(part.Errors) > 0 {
+		mc.handle(id, sub, nil, part.Errors)
+		return false
+	}
+
+	// heartbeat
+	if len(part.Payload) == 0 || string(part.Payload) == "null" {
+		return true
+	}
+
+	return mc.handleResult(id, sub, part.Payload)
+}
+
+// multipartReader reads the parts of a multipart/mixed stream.
+// Unlike mime/multipart, a part is returned as soon as its closing delimiter is read,
+// without waiting for the beginning of the next part
+type multipartReader struct {
+	r       io.Reader
+	delim   []byte
+	buf     []byte
+	chunk   []byte
+	err     error
+	started bool
+}
+
+func newMultipartReader(r io.Reader, boundary string) *multipartReader {
+	return &multipartReader{
+		r:     r,
+		delim: []byte("\r\n--" + boundary),
+		// the first delimiter may not be preceded by a line break
+		buf:   []byte("\r\n"),
+		chunk: make([]byte, 4096),
+	}
+}
+
+// next returns the body of the next part. It returns io.EOF after the close delimiter
+func (mr *multipartReader) next() ([]byte, error) {
+	for {
+		if mr.started && bytes.HasPrefix(mr.buf, []byte("--")) {
+			return nil, io.EOF
+		}
+
+		if i := bytes.Index(mr.buf, mr.delim); i >= 0 {
+			segment := append([]byte(nil), mr.buf[:i]...)
+			mr.buf = mr.buf[i+len(mr.delim):]
+			// skip the preamble
+			if !mr.started {
+				mr.started = true
+				continue
+			}
+			return partBody(segment), nil
+		}
+
+		if mr.err != nil {
+			if errors.Is(mr.err, io.EOF) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, mr.err
+		}
+
+		// the buffered parts are returned before the read error
+		var n int
+		n, mr.err = mr.r.Read(mr.chunk)
+		mr.buf = append(mr.buf, mr.chunk[:n]...)
+	}
+}
+
+// partBody strips the headers of the part
+func partBody(segment []byte) []byte {
+	if i := bytes.Index(segment, []byte("\r\n\r\n")); i >= 0 {
+		return segment[i+4:]
+	}
+	if i := bytes.Index(segment, []byte("\n\n")); i >= 0 {
+		return segment[i+2:]
+	}
+	return segment
+}
@@ -0,0 +1,190 @@
+package graphql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeMultipartPart writes the part followed by the delimiter, as Apollo Router does
+func writeMultipartPart(w http.ResponseWriter, body string) {
+	_, _ = fmt.Fprintf(w, "\r\ncontent-type: application/json\r\n\r\n%s\r\n--graphql", body)
+	w.(http.Flusher).Flush()
+}
+
+func newMultipartTestServer(t *testing.T, fn func(w http.ResponseWriter, r *http.Request)) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Accept") != MultipartAccept {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", `multipart/mixed;boundary="graphql";subscriptionSpec="1.0"`)
+		w.WriteHeader(http.StatusOK)
+		_, _ = io.WriteString(w, "\r\n--graphql")
+		fn(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func TestMultipartClient_Subscribe(t *testing.T) {
+	url := newMultipartTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		writeMultipartPart(w, `{}`)
+		writeMultipartPart(w, `{"payload":{"data":{"count":1}}}`)
+		writeMultipartPart(w, `{}`)
+		writeMultipartPart(w, `{"payload":{"data":null,"errors":[{"message":"resolver failed"}]}}`)
+		writeMultipartPart(w, `{"payload":{"data":{"count":2}}}`)
+		_, _ = io.WriteString(w, "--\r\n")
+	})
+
+	client := NewMultipartClient(NewClient(url, nil))
+	defer func() { _ = client.Close() }()
+
+	var sub struct {
+		Count int
+	}
+	type result struct {
+		data string
+		err  error
+	}
+	results := make(chan result, 10)
+	_, err := client.Subscribe(&sub, nil, func(message []byte, err error) error {
+		results <- result{string(message), err}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("got error: %v, want: nil", err)
+	}
+
+	for i, want := range []string{`{"count":1}`, "", `{"count":2}`} {
+		select {
+		case got := <-results:
+			if got.data != want {
+				t.Errorf("got message %d: %s (error: %v), want: %s", i, got.data, got.err, want)
+			}
+			if want == "" && (got.err == nil || got.err.Error() != "Message: resolver failed, Locations: []") {
+				t.Errorf("got error: %v, want: resolver failed", got.err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("message wasn't received")
+		}
+	}
+
+	select {
+	case got := <-results:
+		t.Errorf("got unexpected message: %v", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestMultipartClient_TransportError(t *testing.T) {
+	url := newMultipartTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		writeMultipartPart(w, `{"payload":null,"errors":[{"message":"subgraph unreachable"}]}`)
+		<-r.Context().Done()
+	})
+
+	client := NewMultipartClient(NewClient(url, nil))
+	defer func() { _ = client.Close() }()
+
+	errs := make(chan error, 10)
+	id, err := client.Exec(`subscription { count }`, nil, func(message []byte, err error) error {
+		errs <- err
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("got error: %v, want: nil", err)
+	}
+
+	select {
+	case err := <-errs:
+		var gqlErrs Errors
+		if !errors.As(err, &gqlErrs) || gqlErrs[0].Message != "subgraph unreachable" {
+			t.Errorf("got error: %v, want: subgraph unreachable", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("error wasn't received")
+	}
+
+	// the subscription is terminated
+	deadline := time.Now().Add(5 * time.Second)
+	for client.Unsubscribe(id) == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("the subscription wasn't removed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMultipartClient_ContextCancellation(t *testing.T) {
+	disconnected := make(chan struct{})
+	url := newMultipartTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				close(disconnected)
+				return
+			case <-ticker.C:
+				writeMultipartPart(w, `{}`)
+			}
+		}
+	})
+
+	client := NewMultipartClient(NewClient(url, nil))
+	defer func() { _ = client.Close() }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err := client.ExecWithContext(ctx, `subscription { count }`, nil, func(message []byte, err error) error {
+		t.Errorf("got message: %s, error: %v, want: none", message, err)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("got error: %v, want: nil", err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case <-disconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the connection wasn't closed")
+	}
+}
+
+func TestMultipartReader(t *testing.T) {
+	stream := "preamble\r\n--graphql\r\ncontent-type: application/json\r\n\r\n{}" +
+		"\r\n--graphql\r\ncontent-type: application/json\r\n\r\n{\"payload\":null}" +
+		"\r\n--graphql--\r\n"
+
+	reader := newMultipartReader(strings.NewReader(stream), "graphql")
+	for _, want := range []string{`{}`, `{"payload":null}`} {
+		got, err := reader.next()
+		if err != nil {
+			t.Fatalf("got error: %v, want: nil", err)
+		}
+		if string(got) != want {
+			t.Errorf("got part: %s, want: %s", got, want)
+		}
+	}
+	if _, err := reader.next(); !errors.Is(err, io.EOF) {
+		t.Errorf("got error: %v, want: %v", err, io.EOF)
+	}
+
+	// the stream is closed without the close delimiter
+	reader = newMultipartReader(strings.NewReader("--graphql\r\n\r\n{}\r\n--graphql\r\n\r\n{}"), "graphql")
+	if got, err := reader.next(); err != nil || string(got) != `{}` {
+		t.Errorf("got part: %s, error: %v, want: {}", got, err)
+	}
+	if _, err := reader.next(); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("got error: %v, want: %v", err, io.ErrUnexpectedEOF)
+	}
+}
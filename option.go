@@ -7,6 +7,10 @@ const (
 	// optionTypeOperationName is private because it's option is built-in and unique
 	optionTypeOperationName      OptionType = "operation_name"
 	OptionTypeOperationDirective OptionType = "operation_directive"
+	// optionTypeSubscription is private because its options configure the subscription instead of the query
+	optionTypeSubscription OptionType = "subscription"
+	// optionTypeFieldMask is private because its options prune the query fields instead of rendering
+	optionTypeFieldMask OptionType = "field_mask"
 )
 
 // Option abstracts an extra render interface for the query string
@@ -36,3 +40,32 @@ func (ono operationNameOption) String() string {
 func OperationName(name string) Option {
 	return operationNameOption{name}
 }
+
+// subscriptionOption represents the per-subscription settings of SubscriptionClient. It doesn't render anything
+type subscriptionOption struct {
+	apply func(sub *subscription)
+}
+
+func (so subscriptionOption) Type() OptionType {
+	return optionTypeSubscription
+}
+
+func (so subscriptionOption) String() string {
+	return ""
+}
+
+// OnComplete creates the option of SubscriptionClient.Subscribe which calls fn when the server completes the subscription.
+// fn isn't called when the subscription is stopped by the client, or when the connection is lost
+func OnComplete(fn func()) Option {
+	return subscriptionOption{apply: func(sub *subscription) {
+		sub.onComplete = fn
+	}}
+}
+
+// WithErrorPolicy creates the option of SubscriptionClient.Subscribe which sets what happens
+// when the handler of the subscription returns an error. Default ErrorPolicyReport
+func WithErrorPolicy(policy ErrorPolicy) Option {
+	return subscriptionOption{apply: func(sub *subscription) {
+		sub.errorPolicy = policy
+	}}
+}
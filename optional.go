@@ -0,0 +1,165 @@
+package graphql
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+)
+
+// Optional is a tri-state value: undefined, null or a value.
+//
+// In a variables map or in struct variables, an undefined Optional is omitted from the request,
+// so the argument isn't provided, while a null Optional is sent as null. In input objects, add the omitzero
+// json option to omit the undefined Optional fields. The variable is typed as the nullable type of T.
+//
+// In results, an Optional field stays undefined when the field is absent from the response,
+// and is null when the field is null. T must be a scalar, an enum or a list of them.
+//
+// The zero value is undefined.
+type Optional[T any] struct {
+	value T
+	state optionalState
+}
+
+type optionalState uint8
+
+const (
+	optionalUndefined optionalState = iota
+	optionalNull
+	optionalDefined
+)
+
+// NewOptional creates an Optional set to value
+func NewOptional[T any](value T) Optional[T] {
+	return Optional[T]{value: value, state: optionalDefined}
+}
+
+// NewNullOptional creates an Optional set to null
+func NewNullOptional[T any]() Optional[T] {
+	return Optional[T]{state: optionalNull}
+}
+
+// IsDefined reports whether o is null or has a value
+func (o Optional[T]) IsDefined() bool {
+	return o.state != optionalUndefined
+}
+
+// IsNull reports whether o is null
+func (o Optional[T]) IsNull() bool {
+	return o.state == optionalNull
+}
+
+// Get returns the value of o, and whether o has a value
+func (o Optional[T]) Get() (T, bool) {
+	return o.value, o.state == optionalDefined
+}
+
+// Value returns the value of o, or the zero value of T if o is undefined or null
+func (o Optional[T]) Value() T {
+	return o.value
+}
+
+// IsZero reports whether o is undefined, so that it's omitted by the omitzero json option
+func (o Optional[T]) IsZero() bool {
+	return o.state == optionalUndefined
+}
+
+// MarshalJSON encodes the value of o, or null if o is undefined or null
+func (o Optional[T]) MarshalJSON() ([]byte, error) {
+	if o.state != optionalDefined {
+		return []byte("null"), nil
+	}
+	return json.Marshal(o.value)
+}
+
+// UnmarshalJSON sets o to null or to the decoded value
+func (o *Optional[T]) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+		*o = NewNullOptional[T]()
+		return nil
+	}
+	var value T
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	*o = NewOptional(value)
+	return nil
+}
+
+func (o Optional[T]) isUndefined() bool {
+	return o.state == optionalUndefined
+}
+
+func (o Optional[T]) optionalValue() (any, bool) {
+	return o.value, o.state == optionalDefined
+}
+
+func (o Optional[T]) elemType() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+// optional is implemented by the Optional types
+type optional interface {
+	isUndefined() bool
+	// optionalValue returns the value, and whether it's set
+	optionalValue() (any, bool)
+	elemType() reflect.Type
+}
+
+var optionalType = reflect.TypeOf((*optional)(nil)).Elem()
+
+// isUndefinedOptional reports whether v is an undefined Optional, possibly declared with a default value
+func isUndefinedOptional(v reflect.Value) bool {
+	if v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	if v.IsValid() && v.Type() == defaultedVariableType {
+		v = reflect.ValueOf(v.Interface().(DefaultedVariable).value)
+	}
+	if !v.IsValid() || !v.Type().Implements(optionalType) || !v.CanInterface() {
+		return false
+	}
+	return v.Interface().(optional).isUndefined()
+}
+
+// omitUndefinedVariables returns variables without the undefined Optional values.
+// variables is returned as is when it has none
+func omitUndefinedVariables(variables map[string]any) map[string]any {
+	var defined map[string]any
+	for name, value := range variables {
+		if !isUndefinedOptional(reflect.ValueOf(value)) {
+			continue
+		}
+		if defined == nil {
+			defined = make(map[string]any, len(variables))
+			for k, v := range variables {
+				defined[k] = v
+			}
+		}
+		delete(defined, name)
+	}
+	if defined == nil {
+		return variables
+	}
+	return defined
+}
+
+// undefinedFields returns the JSON names of the undefined Optional fields of the struct variables,
+// or nil if variables isn't a struct
+func undefinedFields(variables any) map[string]bool {
+	v := reflect.Indirect(reflect.ValueOf(variables))
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	var undefined map[string]bool
+	for name, fv := range jsonFieldValues(v) {
+		if !isUndefinedOptional(fv) {
+			continue
+		}
+		if undefined == nil {
+			undefined = make(map[string]bool)
+		}
+		undefined[name] = true
+	}
+	return undefined
+}
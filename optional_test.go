@@ -0,0 +1,163 @@
+package graphql_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/llehouerou/go-graphql-client"
+)
+
+func TestOptional_Variables(t *testing.T) {
+	var m struct {
+		UpdateUser struct {
+			ID graphql.ID
+		} `graphql:"updateUser(id: $id, name: $name, email: $email, age: $age)"`
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/graphql", func(w http.ResponseWriter, req *http.Request) {
+		body := mustRead(req.Body)
+		if got, want := body, `{"query":"mutation ($age:Int$email:String$id:ID!$name:String){updateUser(id: $id, name: $name, email: $email, age: $age){id}}","variables":{"email":null,"id":"1","name":"Gopher"}}`+"\n"; got != want {
+			t.Errorf("got body: %v, want %v", got, want)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		mustWrite(w, `{"data": {"updateUser": {"id": "1"}}}`)
+	})
+	client := graphql.NewClient(
+		"/graphql",
+		&http.Client{Transport: localRoundTripper{handler: mux}},
+	)
+
+	variables := map[string]any{
+		"id":    graphql.ID("1"),
+		"name":  graphql.NewOptional("Gopher"),
+		"email": graphql.NewNullOptional[string](),
+		"age":   graphql.Optional[int]{},
+	}
+	if err := client.Mutate(context.Background(), &m, variables); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOptional_StructVariables(t *testing.T) {
+	type userInput struct {
+		Name  graphql.Optional[string]   `json:"name,omitzero"`
+		Email graphql.Optional[string]   `json:"email,omitzero"`
+		Tags  graphql.Optional[[]string] `json:"tags,omitzero"`
+	}
+	variables := struct {
+		ID    graphql.ID               `json:"id"`
+		Input userInput                `json:"input"`
+		Age   graphql.Optional[int]    `json:"age,omitzero"`
+		Score graphql.Optional[*int32] `json:"score"`
+		// undefined Optional fields are omitted without the omitzero option
+		After graphql.Optional[string] `json:"after"`
+	}{
+		ID: "1",
+		Input: userInput{
+			Name:  graphql.NewOptional("Gopher"),
+			Email: graphql.NewNullOptional[string](),
+		},
+		Score: graphql.NewNullOptional[*int32](),
+	}
+
+	var m struct {
+		UpdateUser struct {
+			ID graphql.ID
+		} `graphql:"updateUser(id: $id, input: $input, age: $age, score: $score, after: $after)"`
+	}
+	got, err := graphql.ConstructMutation(&m, variables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "mutation ($after:String$age:Int$id:ID!$input:userInput!$score:Int){updateUser(id: $id, input: $input, age: $age, score: $score, after: $after){id}}"; got != want {
+		t.Errorf("got query: %v, want %v", got, want)
+	}
+
+	req, _, err := graphql.NewClient("/graphql", nil).
+		BuildRequest(context.Background(), got, variables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := mustRead(req.Body), `{"query":"`+got+`","variables":{"id":"1","input":{"name":"Gopher","email":null},"score":null}}`+"\n"; got != want {
+		t.Errorf("got body: %v, want %v", got, want)
+	}
+}
+
+func TestOptional_Unmarshal(t *testing.T) {
+	var q struct {
+		User struct {
+			Name  graphql.Optional[string]
+			Email graphql.Optional[string]
+			Age   graphql.Optional[int]
+			Tags  graphql.Optional[[]string]
+		}
+	}
+	err := graphql.UnmarshalGraphQL([]byte(`{"user": {"name": "Gopher", "email": null, "tags": ["a", "b"]}}`), &q)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if name, ok := q.User.Name.Get(); !ok || name != "Gopher" {
+		t.Errorf("got name: %v, %v, want Gopher", name, ok)
+	}
+	if !q.User.Email.IsDefined() || !q.User.Email.IsNull() {
+		t.Errorf("got email: %+v, want null", q.User.Email)
+	}
+	if q.User.Age.IsDefined() {
+		t.Errorf("got age: %+v, want undefined", q.User.Age)
+	}
+	if tags := q.User.Tags.Value(); len(tags) != 2 || tags[1] != "b" {
+		t.Errorf("got tags: %v, want [a b]", tags)
+	}
+
+	query, err := graphql.ConstructQuery(&q, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "{user{name,email,age,tags}}"; query != want {
+		t.Errorf("got query: %v, want %v", query, want)
+	}
+}
+
+func TestOptional_JSON(t *testing.T) {
+	v := struct {
+		A graphql.Optional[int] `json:"a"`
+		B graphql.Optional[int] `json:"b"`
+		C graphql.Optional[int] `json:"c,omitzero"`
+	}{
+		A: graphql.NewOptional(1),
+		B: graphql.NewNullOptional[int](),
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), `{"a":1,"b":null}`; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestOptional_VariablesJSONOptions(t *testing.T) {
+	type input struct {
+		N     int                   `json:"n,string"`
+		Skip  int                   `json:"skip,omitzero"`
+		Limit graphql.Optional[int] `json:"limit,omitzero"`
+		Extra any                   `json:"extra"`
+	}
+	variables := map[string]any{
+		"in":    input{N: 5},
+		"after": graphql.Optional[string]{},
+	}
+
+	req, _, err := graphql.NewClient("/graphql", nil).
+		BuildRequest(context.Background(), "query{x}", variables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := mustRead(req.Body), `{"query":"query{x}","variables":{"in":{"n":"5","extra":null}}}`+"\n"; got != want {
+		t.Errorf("got body: %v, want %v", got, want)
+	}
+}
@@ -51,9 +51,23 @@ const (
 //	}
 //	func (w Wrapper[T]) GetGraphQLWrapped() T { return w.Value }
 func UnmarshalGraphQL(data []byte, v any) error {
+	return UnmarshalGraphQLWithScalars(data, v, nil)
+}
+
+// ScalarDecoder decodes the JSON value of a custom scalar into v.
+// v is addressable and has the type the decoder is registered for.
+type ScalarDecoder func(data []byte, v reflect.Value) error
+
+// UnmarshalGraphQLWithScalars is like UnmarshalGraphQL, but the values whose type has a decoder
+// in scalars are decoded by this decoder. Pointers and slices of these types are supported.
+func UnmarshalGraphQLWithScalars(
+	data []byte,
+	v any,
+	scalars map[reflect.Type]ScalarDecoder,
+) error {
 	dec := json.NewDecoder(bytes.NewReader(data))
 	dec.UseNumber()
-	err := (&decoder{tokenizer: dec}).Decode(v)
+	err := (&decoder{tokenizer: dec, scalars: scalars}).Decode(v)
 	if err != nil {
 		return err
 	}
@@ -90,6 +104,9 @@ type decoder struct {
 
 	// currentKey holds the current JSON key being processed, used to capture __typename.
 	currentKey string
+
+	// scalars holds the decoders of the custom scalar types
+	scalars map[reflect.Type]ScalarDecoder
 }
 
 type stack []reflect.Value
@@ -339,6 +356,10 @@ func (d *decoder) findFieldsForKey(
 			}
 		}
 
+		if f.IsValid() && (d.isCustomScalar(f.Type()) || isUnmarshalerStruct(f.Type())) {
+			scalar = true
+		}
+
 		fragmentMatch := true
 		fragType := d.vs.fragmentType(i)
 		if fragType != "" && d.currentTypename != "" {
@@ -472,7 +493,7 @@ func (d *decoder) decodeScalarValue(tok any) error {
 		if !v.IsValid() {
 			continue
 		}
-		err := unmarshalValue(tok, v)
+		err := d.unmarshalValue(tok, v)
 		if err != nil {
 			return err
 		}
@@ -513,8 +534,9 @@ func (d *decoder) decodeObjectStart() {
 					d.vs.addStack(v.Field(i), extractFragmentTypename(tag))
 					frontier = append(frontier, v.Field(i))
 				} else if field.Anonymous {
-					// Add embedded struct (not a fragment)
-					d.vs.addStack(v.Field(i), "")
+					// Add embedded struct, named fragments are filtered by their type condition
+					_, typeCondition, _ := reflectutil.GetGraphQLFragment(field.Type)
+					d.vs.addStack(v.Field(i), typeCondition)
 					frontier = append(frontier, v.Field(i))
 				}
 			}
@@ -805,3 +827,76 @@ func unmarshalValue(value any, v reflect.Value) error {
 	v.Set(newVal.Elem())
 	return nil
 }
+
+// unmarshalValue unmarshals JSON value into v, with the decoder of the custom scalar if any.
+func (d *decoder) unmarshalValue(value any, v reflect.Value) error {
+	if raw, ok := value.(json.RawMessage); ok && d.isCustomScalar(v.Type()) {
+		return d.decodeCustomScalar(raw, v)
+	}
+	return unmarshalValue(value, v)
+}
+
+// isUnmarshalerStruct reports whether t is a struct decoded by its UnmarshalJSON method.
+// Like the scalars, its whole JSON value is decoded at once, e.g. the lists of Optional values
+func isUnmarshalerStruct(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && reflect.PointerTo(t).Implements(jsonUnmarshaler)
+}
+
+var jsonUnmarshaler = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// isCustomScalar reports whether t, or the element type of the pointer or slice t, has a scalar decoder.
+func (d *decoder) isCustomScalar(t reflect.Type) bool {
+	if len(d.scalars) == 0 {
+		return false
+	}
+	for {
+		if _, ok := d.scalars[t]; ok {
+			return true
+		}
+		if t.Kind() != reflect.Ptr && t.Kind() != reflect.Slice {
+			return false
+		}
+		t = t.Elem()
+	}
+}
+
+// decodeCustomScalar decodes data into v with the scalar decoder of its type.
+// Pointers are allocated and slices are decoded item by item.
+func (d *decoder) decodeCustomScalar(data []byte, v reflect.Value) error {
+	if decode, ok := d.scalars[v.Type()]; ok {
+		return decode(data, v)
+	}
+
+	isNull := bytes.Equal(bytes.TrimSpace(data), []byte("null"))
+	switch v.Kind() {
+	case reflect.Ptr:
+		if isNull {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		elem := reflect.New(v.Type().Elem())
+		if err := d.decodeCustomScalar(data, elem.Elem()); err != nil {
+			return err
+		}
+		v.Set(elem)
+		return nil
+	case reflect.Slice:
+		if isNull {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		var items []json.RawMessage
+		if err := json.Unmarshal(data, &items); err != nil {
+			return err
+		}
+		s := reflect.MakeSlice(v.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := d.decodeCustomScalar(item, s.Index(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+		return nil
+	}
+	return unmarshalValue(json.RawMessage(data), v)
+}
@@ -2,6 +2,7 @@ package jsonutil_test
 
 import (
 	"encoding/json"
+	"fmt"
 	"reflect"
 	"testing"
 	"time"
@@ -1711,3 +1712,154 @@ func indexOf(s, substr string) int {
 	}
 	return -1
 }
+
+type HumanFields struct {
+	Name   string
+	Height float64
+}
+
+func (HumanFields) GetGraphQLFragment() (string, string) {
+	return "HumanFields", "Human"
+}
+
+type DroidFields struct {
+	Name            string
+	PrimaryFunction string
+}
+
+func (*DroidFields) GetGraphQLFragment() (string, string) {
+	return "DroidFields", "Droid"
+}
+
+func TestUnmarshalGraphQL_namedFragments(t *testing.T) {
+	/*
+		query {
+			hero {
+				__typename
+				...HumanFields
+				...DroidFields
+			}
+			friend {
+				...HumanFields
+			}
+		}
+		fragment HumanFields on Human { name, height }
+		fragment DroidFields on Droid { name, primaryFunction }
+	*/
+	type query struct {
+		Hero struct {
+			Typename string `graphql:"__typename"`
+			HumanFields
+			DroidFields
+		}
+		Friend HumanFields
+	}
+	var got query
+	err := jsonutil.UnmarshalGraphQL([]byte(`{
+		"hero": {
+			"__typename": "Droid",
+			"name": "R2-D2",
+			"primaryFunction": "Astromech"
+		},
+		"friend": {
+			"name": "Luke Skywalker",
+			"height": 1.72
+		}
+	}`), &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want query
+	want.Hero.Typename = "Droid"
+	want.Hero.DroidFields = DroidFields{Name: "R2-D2", PrimaryFunction: "Astromech"}
+	want.Friend = HumanFields{Name: "Luke Skywalker", Height: 1.72}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("not equal:\ngot:  %+v\nwant: %+v", got, want)
+	}
+}
+
+func TestUnmarshalGraphQL_fieldDirectives(t *testing.T) {
+	type query struct {
+		User struct {
+			Name    string
+			Email   string `graphql:"email @skip(if: $anonymous)"`
+			Details *struct {
+				Bio string
+			} `graphql:"details: profile(size: $size) @include(if: $withDetails)"`
+		} `graphql:"user(id: $id)"`
+	}
+
+	var got query
+	err := jsonutil.UnmarshalGraphQL([]byte(`{
+		"user": {
+			"name": "Luke Skywalker",
+			"email": "luke@example.com",
+			"details": {"bio": "Jedi"}
+		}
+	}`), &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.User.Email != "luke@example.com" || got.User.Details == nil || got.User.Details.Bio != "Jedi" {
+		t.Errorf("got: %+v, want: the email and the details", got.User)
+	}
+
+	// the fields excluded by the directives are absent
+	got = query{}
+	err = jsonutil.UnmarshalGraphQL([]byte(`{"user": {"name": "Luke Skywalker"}}`), &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.User.Name != "Luke Skywalker" || got.User.Email != "" || got.User.Details != nil {
+		t.Errorf("got: %+v, want: the name only", got.User)
+	}
+}
+
+func TestUnmarshalGraphQLWithScalars(t *testing.T) {
+	type point struct {
+		X, Y int
+	}
+	scalars := map[reflect.Type]jsonutil.ScalarDecoder{
+		// points are encoded as "x,y" strings
+		reflect.TypeOf(point{}): func(data []byte, v reflect.Value) error {
+			var s string
+			if err := json.Unmarshal(data, &s); err != nil {
+				return err
+			}
+			var p point
+			if _, err := fmt.Sscanf(s, "%d,%d", &p.X, &p.Y); err != nil {
+				return err
+			}
+			v.Set(reflect.ValueOf(p))
+			return nil
+		},
+	}
+
+	type query struct {
+		Start  point
+		End    *point
+		Path   []point
+		Origin *point
+		Name   string
+	}
+	var got query
+	err := jsonutil.UnmarshalGraphQLWithScalars([]byte(`{
+		"start": "1,2",
+		"end": "3,4",
+		"path": ["1,2", "2,3"],
+		"origin": null,
+		"name": "route"
+	}`), &got, scalars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := query{
+		Start: point{1, 2},
+		End:   &point{3, 4},
+		Path:  []point{{1, 2}, {2, 3}},
+		Name:  "route",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("not equal: %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,118 @@
+package graphql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SubscriptionProtocol is a websocket subprotocol implemented by SubscriptionClient
+type SubscriptionProtocol string
+
+const (
+	// ProtocolSubscriptionsTransportWS is Apollo's subscriptions-transport-ws protocol,
+	// negotiated with the graphql-ws subprotocol. This is the default protocol
+	ProtocolSubscriptionsTransportWS SubscriptionProtocol = "graphql-ws"
+	// ProtocolGraphQLWS is the protocol of the graphql-ws library, negotiated with the graphql-transport-ws subprotocol
+	// https://github.com/enisdenjo/graphql-ws/blob/master/PROTOCOL.md
+	ProtocolGraphQLWS SubscriptionProtocol = "graphql-transport-ws"
+)
+
+// ProtocolNegotiationError is returned when the subprotocol accepted by the server
+// doesn't match any of the protocols offered by the client
+type ProtocolNegotiationError struct {
+	// Offered lists the subprotocols offered by the client during the handshake
+	Offered []string
+	// Returned is the subprotocol returned by the server, empty if the server didn't select any
+	Returned string
+}
+
+func (e *ProtocolNegotiationError) Error() string {
+	return fmt.Sprintf(
+		"websocket subprotocol negotiation failed: offered [%s], server returned %q",
+		strings.Join(e.Offered, ", "),
+		e.Returned,
+	)
+}
+
+// encodeType converts the message type of the client, using the subscriptions-transport-ws names,
+// to the message type of the protocol. It returns false if the message doesn't exist in the protocol
+func (p SubscriptionProtocol) encodeType(t OperationMessageType) (OperationMessageType, bool) {
+	if p != ProtocolGraphQLWS {
+		return t, true
+	}
+
+	switch t {
+	case GQL_START:
+		return GQL_SUBSCRIBE, true
+	case GQL_STOP:
+		return GQL_COMPLETE, true
+	case GQL_CONNECTION_TERMINATE:
+		// the connection is terminated by closing the websocket
+		return t, false
+	default:
+		return t, true
+	}
+}
+
+// decodeType converts the message type of the server to the subscriptions-transport-ws names
+func (p SubscriptionProtocol) decodeType(t OperationMessageType) OperationMessageType {
+	if p == ProtocolGraphQLWS && t == GQL_NEXT {
+		return GQL_DATA
+	}
+	return t
+}
+
+// subprotocols returns the subprotocols offered during the handshake, in order of preference
+func (sc *SubscriptionClient) subprotocols() []string {
+	subprotocols := make([]string, 0, len(sc.protocols)+len(sc.websocketOptions.Subprotocols))
+	for _, p := range sc.protocols {
+		subprotocols = append(subprotocols, string(p))
+	}
+	return append(subprotocols, sc.websocketOptions.Subprotocols...)
+}
+
+// negotiateProtocol selects the protocol matching the subprotocol accepted by the server.
+// Connections which don't expose the subprotocol, and servers which don't return any
+// while a single protocol is offered, use the preferred protocol
+func (sc *SubscriptionClient) negotiateProtocol(conn WebsocketConn) (SubscriptionProtocol, error) {
+	sp, ok := conn.(interface{ Subprotocol() string })
+	if !ok {
+		return sc.protocols[0], nil
+	}
+
+	returned := sp.Subprotocol()
+	if returned == "" && len(sc.protocols) == 1 {
+		return sc.protocols[0], nil
+	}
+	for _, p := range sc.protocols {
+		if string(p) == returned {
+			return p, nil
+		}
+	}
+
+	return "", &ProtocolNegotiationError{
+		Offered:  sc.subprotocols(),
+		Returned: returned,
+	}
+}
+
+// WithProtocols sets the protocols offered to the server during the websocket handshake, in order of preference.
+// The protocol of the connection is selected from the subprotocol accepted by the server.
+// Default ProtocolSubscriptionsTransportWS
+func (sc *SubscriptionClient) WithProtocols(protocols ...SubscriptionProtocol) *SubscriptionClient {
+	if len(protocols) > 0 {
+		sc.protocols = protocols
+	}
+	return sc
+}
+
+// Protocol returns the protocol negotiated for the current connection, or an empty string if the client isn't connected
+func (sc *SubscriptionClient) Protocol() SubscriptionProtocol {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.conn == nil {
+		return ""
+	}
+	return sc.protocol
+}
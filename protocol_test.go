@@ -0,0 +1,131 @@
+package graphql
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newProtocolTestServer starts a websocket server accepting the subprotocols, which runs fn for each accepted connection
+func newProtocolTestServer(t *testing.T, subprotocols []string, fn func(c *websocket.Conn)) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{Subprotocols: subprotocols}
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer func() { _ = c.Close() }()
+		fn(c)
+	}))
+	t.Cleanup(server.Close)
+
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func TestSubscriptionClient_GraphQLWSProtocol(t *testing.T) {
+	pong := make(chan struct{})
+	wsURL := newProtocolTestServer(t, []string{"graphql-transport-ws"}, func(c *websocket.Conn) {
+		for {
+			var msg OperationMessage
+			if err := c.ReadJSON(&msg); err != nil {
+				return
+			}
+			switch msg.Type {
+			case GQL_CONNECTION_INIT:
+				_ = c.WriteJSON(map[string]string{"type": string(GQL_CONNECTION_ACK)})
+				_ = c.WriteJSON(map[string]string{"type": string(GQL_PING)})
+			case GQL_PONG:
+				close(pong)
+			case GQL_SUBSCRIBE:
+				_ = c.WriteJSON(map[string]any{
+					"id":      msg.ID,
+					"type":    string(GQL_NEXT),
+					"payload": map[string]any{"data": map[string]int{"test": 1}},
+				})
+				_ = c.WriteJSON(map[string]any{
+					"id":   msg.ID,
+					"type": string(GQL_COMPLETE),
+				})
+			}
+		}
+	})
+
+	client := NewSubscriptionClient(wsURL).
+		WithTimeout(5*time.Second).
+		WithProtocols(ProtocolSubscriptionsTransportWS, ProtocolGraphQLWS)
+	defer func() { _ = client.Close() }()
+
+	messages := make(chan string, 1)
+	protocols := make(chan SubscriptionProtocol, 1)
+	if _, err := client.Exec(`subscription { test }`, nil, func(message []byte, err error) error {
+		if err != nil {
+			t.Errorf("got error: %v, want: nil", err)
+			return nil
+		}
+		protocols <- client.Protocol()
+		messages <- string(message)
+		return nil
+	}); err != nil {
+		t.Fatalf("got error: %v, want: nil", err)
+	}
+	go func() { _ = client.Run() }()
+
+	select {
+	case got := <-messages:
+		if got != `{"test":1}` {
+			t.Errorf("got message: %s, want: {\"test\":1}", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("message wasn't received")
+	}
+	if got := <-protocols; got != ProtocolGraphQLWS {
+		t.Errorf("got protocol: %s, want: %s", got, ProtocolGraphQLWS)
+	}
+
+	select {
+	case <-pong:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ping wasn't answered")
+	}
+}
+
+func TestSubscriptionClient_ProtocolNegotiationError(t *testing.T) {
+	wsURL := newProtocolTestServer(t, nil, func(c *websocket.Conn) {
+		_, _, _ = c.ReadMessage()
+	})
+
+	client := NewSubscriptionClient(wsURL).
+		WithTimeout(5*time.Second).
+		WithRetryTimeout(time.Minute).
+		WithProtocols(ProtocolGraphQLWS, ProtocolSubscriptionsTransportWS)
+	defer func() { _ = client.Close() }()
+
+	if _, err := client.Exec(`subscription { test }`, nil, func(message []byte, err error) error {
+		return nil
+	}); err != nil {
+		t.Fatalf("got error: %v, want: nil", err)
+	}
+
+	errChan := make(chan error, 1)
+	go func() { errChan <- client.Run() }()
+
+	select {
+	case err := <-errChan:
+		var negotiationErr *ProtocolNegotiationError
+		if !errors.As(err, &negotiationErr) {
+			t.Fatalf("got error: %v, want: ProtocolNegotiationError", err)
+		}
+		want := `websocket subprotocol negotiation failed: offered [graphql-transport-ws, graphql-ws], server returned ""`
+		if err.Error() != want {
+			t.Errorf("got error: %s, want: %s", err, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the negotiation error wasn't returned")
+	}
+}
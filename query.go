@@ -1,14 +1,17 @@
 package graphql
 
 import (
+	"encoding/json"
 	"fmt"
 	"reflect"
+	"sort"
 	"strings"
 )
 
 type constructOptionsOutput struct {
 	operationName       string
 	operationDirectives []string
+	fieldMask           *fieldMask
 }
 
 func (coo constructOptionsOutput) OperationDirectivesString() string {
@@ -27,10 +30,21 @@ func constructOptions(options []Option) (*constructOptionsOutput, error) {
 		case optionTypeOperationName:
 			output.operationName = option.String()
 		case OptionTypeOperationDirective:
+			if directive, ok := option.(Directive); ok && directive.err != nil {
+				return nil, directive.err
+			}
 			output.operationDirectives = append(
 				output.operationDirectives,
 				option.String(),
 			)
+		case optionTypeSubscription:
+			// applied by the subscription client
+		case optionTypeFieldMask:
+			mask, err := output.fieldMask.add(option.(fieldMaskOption))
+			if err != nil {
+				return nil, err
+			}
+			output.fieldMask = mask
 		default:
 			return nil, fmt.Errorf("invalid query option type: %s", option.Type())
 		}
@@ -51,44 +65,154 @@ func hasVariables(variables any) bool {
 	return reflectVal.Kind() != reflect.Map || reflectVal.Len() > 0
 }
 
+// validateVariables cross-checks the variables referenced by the query with the declared variables.
+// Every referenced variable must be declared, and every declared variable must be referenced,
+// possibly by a struct field pruned by the field mask
+func validateVariables(refs *variableRefs, variables any) error {
+	declared := make(map[string]bool)
+	switch v := variables.(type) {
+	case nil:
+	case map[string]any:
+		for name := range v {
+			declared[name] = true
+		}
+	default:
+		for _, f := range collectStructFieldsForArguments(variables) {
+			declared[f.jsonName] = true
+		}
+	}
+
+	for _, name := range refs.names {
+		if !declared[name] {
+			return fmt.Errorf("%s references the undeclared variable $%s", refs.locations[name], name)
+		}
+	}
+
+	unused := make([]string, 0, len(declared))
+	for name := range declared {
+		if _, ok := refs.locations[name]; !ok && !refs.pruned[name] {
+			unused = append(unused, name)
+		}
+	}
+	if len(unused) > 0 {
+		sort.Strings(unused)
+		return fmt.Errorf("variable $%s is declared but not referenced by the query", unused[0])
+	}
+	return nil
+}
+
+// omitVariableMap returns variables without the variables in omitted.
+// variables is returned as is when there is nothing to omit
+func omitVariableMap(variables map[string]any, omitted map[string]bool) map[string]any {
+	if len(omitted) == 0 {
+		return variables
+	}
+	out := make(map[string]any, len(variables))
+	for name, value := range variables {
+		if !omitted[name] {
+			out[name] = value
+		}
+	}
+	return out
+}
+
+// omitVariables returns the map or struct variables without the variables in omitted.
+// The struct variables are converted into a map of their JSON values, except the values
+// which contain types registered in scalars, so that they're encoded by their scalar.
+// variables is returned as is when there is nothing to omit
+func omitVariables(
+	variables any,
+	omitted map[string]bool,
+	scalars *ScalarRegistry,
+) (any, error) {
+	if len(omitted) == 0 || variables == nil {
+		return variables, nil
+	}
+	if m, ok := variables.(map[string]any); ok {
+		return omitVariableMap(m, omitted), nil
+	}
+
+	data, err := json.Marshal(variables)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode variables: %w", err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("failed to encode variables: %w", err)
+	}
+	values := jsonFieldValues(reflect.Indirect(reflect.ValueOf(variables)))
+	out := make(map[string]any, len(fields))
+	for name, raw := range fields {
+		if omitted[name] {
+			continue
+		}
+		out[name] = raw
+		if fv, ok := values[name]; ok && scalars != nil && len(scalars.scalars) > 0 &&
+			scalars.needsEncoding(fv.Type(), make(map[reflect.Type]bool)) {
+			out[name] = fv.Interface()
+		}
+	}
+	return out, nil
+}
+
 // constructOperation builds a GraphQL operation string from struct and variables.
 // operationType should be "query", "mutation", or "subscription".
 // includeOperationTypeInDefault determines whether to prepend the operation type
 // when no operation name or directives are specified (true for mutation/subscription, false for query).
+// scalars maps the registered Go types to their GraphQL scalar, it may be nil.
+//
+// The variables referenced only by the struct fields pruned by the field mask are omitted
+// from the operation, and returned so that they're removed from the request variables.
 func constructOperation(
 	operationType string,
 	v any,
 	variables any,
 	includeOperationTypeInDefault bool,
+	scalars *ScalarRegistry,
 	options ...Option,
-) (string, error) {
-	query, err := query(v)
+) (string, map[string]bool, error) {
+	optionsOutput, err := constructOptions(options)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
-	optionsOutput, err := constructOptions(options)
+	query, refs, err := query(v, scalars, optionsOutput.fieldMask)
 	if err != nil {
-		return "", err
+		return "", nil, err
+	}
+
+	for _, directive := range optionsOutput.operationDirectives {
+		refs.add(directive, "operation directive "+directive)
+	}
+	if err := validateVariables(refs, variables); err != nil {
+		return "", nil, err
 	}
 
+	omitted := refs.omitted()
 	if hasVariables(variables) {
-		return fmt.Sprintf(
-			"%s %s(%s)%s%s",
-			operationType,
-			optionsOutput.operationName,
-			queryArguments(variables),
-			optionsOutput.OperationDirectivesString(),
-			query,
-		), nil
+		arguments, err := queryArguments(variables, scalars, omitted)
+		if err != nil {
+			return "", nil, err
+		}
+		// all the variables may be omitted
+		if arguments != "" || len(omitted) == 0 {
+			return fmt.Sprintf(
+				"%s %s(%s)%s%s",
+				operationType,
+				optionsOutput.operationName,
+				arguments,
+				optionsOutput.OperationDirectivesString(),
+				query,
+			), omitted, nil
+		}
 	}
 
 	if optionsOutput.operationName == "" &&
 		len(optionsOutput.operationDirectives) == 0 {
 		if includeOperationTypeInDefault {
-			return operationType + query, nil
+			return operationType + query, omitted, nil
 		}
-		return query, nil
+		return query, omitted, nil
 	}
 
 	return fmt.Sprintf(
@@ -97,37 +221,49 @@ func constructOperation(
 		optionsOutput.operationName,
 		optionsOutput.OperationDirectivesString(),
 		query,
-	), nil
+	), omitted, nil
 }
 
 // ConstructQuery builds GraphQL query string from struct and variables.
 //
 // The variables parameter must be either nil, a map[string]any, or a struct/pointer to struct
 // with json tags. Passing any other type will cause a panic (programming error).
+//
+// An error is returned if the graphql tags reference a variable which isn't declared, or if
+// a declared variable isn't referenced.
 func ConstructQuery(v any, variables any, options ...Option) (string, error) {
-	return constructOperation("query", v, variables, false, options...)
+	query, _, err := constructOperation("query", v, variables, false, nil, options...)
+	return query, err
 }
 
 // ConstructMutation builds GraphQL mutation string from struct and variables.
 //
 // The variables parameter must be either nil, a map[string]any, or a struct/pointer to struct
 // with json tags. Passing any other type will cause a panic (programming error).
+//
+// An error is returned if the graphql tags reference a variable which isn't declared, or if
+// a declared variable isn't referenced.
 func ConstructMutation(
 	v any,
 	variables any,
 	options ...Option,
 ) (string, error) {
-	return constructOperation("mutation", v, variables, true, options...)
+	query, _, err := constructOperation("mutation", v, variables, true, nil, options...)
+	return query, err
 }
 
 // ConstructSubscription builds GraphQL subscription string from struct and variables.
 //
 // The variables parameter must be either nil, a map[string]any, or a struct/pointer to struct
 // with json tags. Passing any other type will cause a panic (programming error).
+//
+// An error is returned if the graphql tags reference a variable which isn't declared, or if
+// a declared variable isn't referenced.
 func ConstructSubscription(
 	v any,
 	variables any,
 	options ...Option,
 ) (string, error) {
-	return constructOperation("subscription", v, variables, true, options...)
+	query, _, err := constructOperation("subscription", v, variables, true, nil, options...)
+	return query, err
 }
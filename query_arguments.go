@@ -6,8 +6,11 @@ import (
 	"io"
 	"reflect"
 	"sort"
+	"strings"
 
 	"github.com/llehouerou/go-graphql-client/internal/reflectutil"
+	"github.com/llehouerou/go-graphql-client/internal/tagparser"
+	"github.com/llehouerou/go-graphql-client/types"
 )
 
 // argumentFieldInfo holds information about a struct field used for GraphQL query arguments.
@@ -15,6 +18,38 @@ type argumentFieldInfo struct {
 	jsonName  string
 	fieldType reflect.Type
 	value     reflect.Value
+	// graphqlType overrides the inferred GraphQL type when set
+	graphqlType string
+	// defaultValue is the default value of the variable when set
+	defaultValue string
+	// omittable is true if the field is an Optional, whose undefined value is omitted,
+	// or if the json tag omits the empty or zero value of the field
+	omittable bool
+}
+
+// validateDefault returns an error if the default value of the field isn't a GraphQL constant value,
+// or if the field is always sent in the variables, so that the default value never applies
+func (f argumentFieldInfo) validateDefault() error {
+	if f.defaultValue == "" {
+		return nil
+	}
+	if err := tagparser.ValidateConstValue(f.defaultValue); err != nil {
+		return fmt.Errorf(
+			"variable %s: invalid %s tag %q: %w",
+			f.jsonName,
+			types.GraphQLDefaultTag,
+			f.defaultValue,
+			err,
+		)
+	}
+	if !f.omittable {
+		return fmt.Errorf(
+			"variable %s: the %s tag requires the omitempty or omitzero json option, or an Optional field, the default value never applies to a variable which is always sent",
+			f.jsonName,
+			types.GraphQLDefaultTag,
+		)
+	}
+	return nil
 }
 
 // queryArguments constructs a minified arguments string for variables.
@@ -26,24 +61,52 @@ type argumentFieldInfo struct {
 // This function will panic if variables is any other type (e.g., string, int, slice).
 // This panic is intentional and indicates a programming error - incorrect API usage.
 //
+// The Go types registered in scalars are written with the name of their scalar, scalars may be nil.
+//
+// The default values of the variables are written after their type.
+// An error is returned if a default value can't be rendered, or if the graphql_default tag is invalid.
+//
+// The variables in omitted aren't written, omitted may be nil.
+//
 // E.g., map[string]any{"a": int(123), "b": true} -> "$a:Int!$b:Boolean!".
-func queryArguments(variables any) string {
+func queryArguments(
+	variables any,
+	scalars *ScalarRegistry,
+	omitted map[string]bool,
+) (string, error) {
 	var buf bytes.Buffer
+	var err error
 
 	switch v := variables.(type) {
 	case map[string]any:
-		writeArgumentsFromMap(&buf, v)
+		err = writeArgumentsFromMap(&buf, omitVariableMap(v, omitted), scalars)
 	default:
-		fields := collectStructFieldsForArguments(variables)
-		writeArgumentsFromFields(&buf, fields)
+		var fields []argumentFieldInfo
+		for _, f := range collectStructFieldsForArguments(variables) {
+			if omitted[f.jsonName] {
+				continue
+			}
+			if err := f.validateDefault(); err != nil {
+				return "", err
+			}
+			fields = append(fields, f)
+		}
+		writeArgumentsFromFields(&buf, fields, scalars)
+	}
+	if err != nil {
+		return "", err
 	}
 
-	return buf.String()
+	return buf.String(), nil
 }
 
 // writeArgumentsFromMap writes GraphQL query arguments from a map of variables.
 // Keys are sorted alphabetically for deterministic output.
-func writeArgumentsFromMap(buf *bytes.Buffer, variables map[string]any) {
+func writeArgumentsFromMap(
+	buf *bytes.Buffer,
+	variables map[string]any,
+	scalars *ScalarRegistry,
+) error {
 	var keys []string
 	for k := range variables {
 		keys = append(keys, k)
@@ -54,12 +117,38 @@ func writeArgumentsFromMap(buf *bytes.Buffer, variables map[string]any) {
 		_, _ = io.WriteString(buf, "$")
 		_, _ = io.WriteString(buf, k)
 		_, _ = io.WriteString(buf, ":")
-		writeArgumentType(buf, reflect.TypeOf(variables[k]), variables[k], true)
+		if err := writeArgumentWithDefault(buf, scalars, k, variables[k]); err != nil {
+			return err
+		}
 	}
+	return nil
+}
+
+// writeArgumentWithDefault writes the type of the variable, followed by its default value
+// if it's a DefaultedVariable
+func writeArgumentWithDefault(
+	buf *bytes.Buffer,
+	scalars *ScalarRegistry,
+	name string,
+	variable any,
+) error {
+	defaulted, ok := variable.(DefaultedVariable)
+	if !ok {
+		writeArgumentType(buf, scalars, reflect.TypeOf(variable), variable, true)
+		return nil
+	}
+	if defaulted.err != nil {
+		return fmt.Errorf("variable %s: %w", name, defaulted.err)
+	}
+	writeArgumentType(buf, scalars, reflect.TypeOf(defaulted.value), defaulted.value, true)
+	_, _ = io.WriteString(buf, " = "+defaulted.defaultValue)
+	return nil
 }
 
 // collectStructFieldsForArguments extracts field information from a struct for use in GraphQL arguments.
 // It validates the struct, collects exported fields with json tags, and returns them sorted by json name.
+// The graphql_type tag of a field overrides its inferred GraphQL type,
+// and the graphql_default tag declares its default value.
 //
 // Panics if variables is not a struct or pointer to struct. This panic indicates a programming error
 // and should be caught during development. The variables parameter must be a struct type; use
@@ -99,8 +188,12 @@ func collectStructFieldsForArguments(variables any) []argumentFieldInfo {
 
 		// Extract field name from json tag (before comma if present)
 		jsonName := jsonTag
+		omittable := field.Type.Implements(optionalType)
 		if commaIdx := bytes.IndexByte([]byte(jsonTag), ','); commaIdx > -1 {
 			jsonName = jsonTag[:commaIdx]
+			for _, option := range strings.Split(jsonTag[commaIdx+1:], ",") {
+				omittable = omittable || option == "omitempty" || option == "omitzero"
+			}
 		}
 
 		// Skip if field name is empty after extraction
@@ -109,9 +202,14 @@ func collectStructFieldsForArguments(variables any) []argumentFieldInfo {
 		}
 
 		fields = append(fields, argumentFieldInfo{
-			jsonName:  jsonName,
-			fieldType: field.Type,
-			value:     val.Field(i),
+			jsonName:    jsonName,
+			fieldType:   field.Type,
+			value:       val.Field(i),
+			graphqlType: strings.TrimSpace(field.Tag.Get(types.GraphQLTypeTag)),
+			defaultValue: strings.TrimSpace(
+				field.Tag.Get(types.GraphQLDefaultTag),
+			),
+			omittable: omittable,
 		})
 	}
 
@@ -124,19 +222,55 @@ func collectStructFieldsForArguments(variables any) []argumentFieldInfo {
 }
 
 // writeArgumentsFromFields writes GraphQL query arguments from collected field information.
-func writeArgumentsFromFields(buf *bytes.Buffer, fields []argumentFieldInfo) {
+func writeArgumentsFromFields(
+	buf *bytes.Buffer,
+	fields []argumentFieldInfo,
+	scalars *ScalarRegistry,
+) {
 	for _, f := range fields {
 		_, _ = io.WriteString(buf, "$")
 		_, _ = io.WriteString(buf, f.jsonName)
 		_, _ = io.WriteString(buf, ":")
-		writeArgumentType(buf, f.fieldType, f.value.Interface(), true)
+		if f.graphqlType != "" {
+			_, _ = io.WriteString(buf, f.graphqlType)
+		} else {
+			writeArgumentType(buf, scalars, f.fieldType, f.value.Interface(), true)
+		}
+		if f.defaultValue != "" {
+			_, _ = io.WriteString(buf, " = "+f.defaultValue)
+		}
 	}
 }
 
 // writeArgumentType writes a minified GraphQL type for t to w.
 // value indicates whether t is a value (required) type or pointer (optional) type.
 // If value is true, then "!" is written at the end of t.
-func writeArgumentType(w io.Writer, t reflect.Type, v any, value bool) {
+// The name of the scalar registered for t in scalars takes precedence over the inferred type.
+func writeArgumentType(
+	w io.Writer,
+	scalars *ScalarRegistry,
+	t reflect.Type,
+	v any,
+	value bool,
+) {
+	if name, ok := scalars.typeName(t); ok {
+		_, _ = io.WriteString(w, name)
+		if value {
+			_, _ = io.WriteString(w, "!")
+		}
+		return
+	}
+
+	if t.Implements(optionalType) {
+		// Optional is a nullable type, so no "!" at the end of its value type.
+		var inner any
+		if o, ok := v.(optional); ok {
+			inner, _ = o.optionalValue()
+		}
+		elem := reflect.Zero(t).Interface().(optional).elemType()
+		writeArgumentType(w, scalars, elem, inner, false)
+		return
+	}
 
 	if reflectutil.ImplementsGraphQLType(t) {
 		value = t.Kind() != reflect.Ptr
@@ -165,7 +299,7 @@ func writeArgumentType(w io.Writer, t reflect.Type, v any, value bool) {
 
 	if t.Kind() == reflect.Ptr {
 		// Pointer is an optional type, so no "!" at the end of the pointer's underlying type.
-		writeArgumentType(w, t.Elem(), v, false)
+		writeArgumentType(w, scalars, t.Elem(), v, false)
 		return
 	}
 
@@ -176,7 +310,7 @@ func writeArgumentType(w io.Writer, t reflect.Type, v any, value bool) {
 		case reflect.Slice, reflect.Array:
 			// List. E.g., "[Int]".
 			_, _ = io.WriteString(w, "[")
-			writeArgumentType(w, t.Elem(), nil, true)
+			writeArgumentType(w, scalars, t.Elem(), nil, true)
 			_, _ = io.WriteString(w, "]")
 		case reflect.Float32, reflect.Float64:
 			_, _ = io.WriteString(w, "Float")
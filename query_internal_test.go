@@ -11,7 +11,7 @@ import (
 func TestWriteArgumentsFromMap(t *testing.T) {
 	t.Run("empty map produces empty string", func(t *testing.T) {
 		var buf bytes.Buffer
-		writeArgumentsFromMap(&buf, map[string]any{})
+		writeArgumentsFromMap(&buf, map[string]any{}, nil)
 
 		if buf.String() != "" {
 			t.Errorf("expected empty string, got %q", buf.String())
@@ -22,7 +22,7 @@ func TestWriteArgumentsFromMap(t *testing.T) {
 		var buf bytes.Buffer
 		writeArgumentsFromMap(&buf, map[string]any{
 			"id": 123,
-		})
+		}, nil)
 
 		expected := "$id:Int!"
 		if buf.String() != expected {
@@ -36,7 +36,7 @@ func TestWriteArgumentsFromMap(t *testing.T) {
 			"zebra":  "test",
 			"apple":  42,
 			"banana": true,
-		})
+		}, nil)
 
 		expected := "$apple:Int!$banana:Boolean!$zebra:String!"
 		if buf.String() != expected {
@@ -51,7 +51,7 @@ func TestWriteArgumentsFromMap(t *testing.T) {
 			"num":   42,
 			"float": 3.14,
 			"bool":  true,
-		})
+		}, nil)
 
 		result := buf.String()
 		// Check that all types are present (order is alphabetical)
@@ -303,7 +303,7 @@ func TestCollectStructFieldsForArguments(t *testing.T) {
 func TestWriteArgumentsFromFields(t *testing.T) {
 	t.Run("empty fields produces empty string", func(t *testing.T) {
 		var buf bytes.Buffer
-		writeArgumentsFromFields(&buf, []argumentFieldInfo{})
+		writeArgumentsFromFields(&buf, []argumentFieldInfo{}, nil)
 
 		if buf.String() != "" {
 			t.Errorf("expected empty string, got %q", buf.String())
@@ -320,7 +320,7 @@ func TestWriteArgumentsFromFields(t *testing.T) {
 			},
 		}
 
-		writeArgumentsFromFields(&buf, fields)
+		writeArgumentsFromFields(&buf, fields, nil)
 
 		expected := "$id:Int!"
 		if buf.String() != expected {
@@ -348,7 +348,7 @@ func TestWriteArgumentsFromFields(t *testing.T) {
 			},
 		}
 
-		writeArgumentsFromFields(&buf, fields)
+		writeArgumentsFromFields(&buf, fields, nil)
 
 		expected := "$name:String!$age:Int!$active:Boolean!"
 		if buf.String() != expected {
@@ -367,7 +367,7 @@ func TestWriteArgumentsFromFields(t *testing.T) {
 			},
 		}
 
-		writeArgumentsFromFields(&buf, fields)
+		writeArgumentsFromFields(&buf, fields, nil)
 
 		// Pointer types should not have ! at the end
 		expected := "$optional:Int"
@@ -526,7 +526,7 @@ func TestQuery_ErrorPath(t *testing.T) {
 		// Use a map type which is not supported and should cause an error
 		invalidQuery := map[string]string{"key": "value"}
 
-		_, err := query(invalidQuery)
+		_, _, err := query(invalidQuery, nil, nil)
 		if err == nil {
 			t.Fatal("expected error from query with map type, got nil")
 		}
@@ -586,7 +586,7 @@ func TestConstructSubscription(t *testing.T) {
 							} `graphql:"users(first:10)"`
 						}
 					} `graphql:"issue(number: $issueNumber)"`
-				} `graphql:"repository(owner: $repositoryOwner, name: $repositoryName, review: $userReview)"`
+				} `graphql:"repository(owner: $repositoryOwner, name: $repositoryName, review: $review)"`
 			}{},
 			inVariables: map[string]any{
 				"repositoryOwner": "shurcooL-test",
@@ -594,7 +594,7 @@ func TestConstructSubscription(t *testing.T) {
 				"issueNumber":     1,
 				"review":          UserReview{},
 			},
-			want: `subscription SearchRepository($issueNumber:Int!$repositoryName:String!$repositoryOwner:String!$review:user_review!){repository(owner: $repositoryOwner, name: $repositoryName, review: $userReview){issue(number: $issueNumber){reactionGroups{users(first:10){nodes{login}}}}}}`,
+			want: `subscription SearchRepository($issueNumber:Int!$repositoryName:String!$repositoryOwner:String!$review:user_review!){repository(owner: $repositoryOwner, name: $repositoryName, review: $review){issue(number: $issueNumber){reactionGroups{users(first:10){nodes{login}}}}}}`,
 		},
 		// Embedded structs without graphql tag should be inlined in query.
 		{
@@ -798,7 +798,10 @@ func TestQueryArguments(t *testing.T) {
 		},
 	}
 	for i, tc := range tests {
-		got := queryArguments(tc.in)
+		got, err := queryArguments(tc.in, nil, nil)
+		if err != nil {
+			t.Fatalf("test case %d: %v", i, err)
+		}
 		if got != tc.want {
 			t.Errorf("test case %d:\n got: %q\nwant: %q", i, got, tc.want)
 		}
@@ -1058,6 +1061,29 @@ func TestQueryArguments_StructVariables(t *testing.T) {
 			},
 			want: "$optional:[IssueState!]$states:[IssueState!]!",
 		},
+		{
+			name: "struct with graphql_type overrides",
+			in: struct {
+				Amount  int64    `json:"amount" graphql_type:"BigInt!"`
+				Limit   *int     `json:"limit" graphql_type:"PositiveInt"`
+				IDs     []string `json:"ids" graphql_type:"[ID!]!"`
+				Cursors []string `json:"cursors,omitempty" graphql_type:"[Cursor]"`
+				Name    string   `json:"name"`
+			}{
+				Amount: 5000000000,
+				IDs:    []string{"a", "b"},
+			},
+			want: "$amount:BigInt!$cursors:[Cursor]$ids:[ID!]!$limit:PositiveInt$name:String!",
+		},
+		{
+			name: "struct with graphql_default tags",
+			in: struct {
+				First *int    `json:"first,omitempty" graphql_default:"10"`
+				Order string  `json:"order,omitempty" graphql_type:"Order!" graphql_default:"ASC"`
+				Query *string `json:"query,omitzero" graphql_default:"\"*\""`
+			}{},
+			want: `$first:Int = 10$order:Order! = ASC$query:String = "*"`,
+		},
 		{
 			name: "struct with custom GraphQLType",
 			in: struct {
@@ -1079,7 +1105,10 @@ func TestQueryArguments_StructVariables(t *testing.T) {
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			got := queryArguments(tc.in)
+			got, err := queryArguments(tc.in, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
 			if got != tc.want {
 				t.Errorf(
 					"\ngot:  %q\nwant: %q",
@@ -1158,7 +1187,7 @@ func TestQueryArguments_InvalidTypes(t *testing.T) {
 					)
 				}
 			}()
-			_ = queryArguments(tc.in)
+			_, _ = queryArguments(tc.in, nil, nil)
 		})
 	}
 }
@@ -2090,3 +2119,419 @@ func TestHasVariables(t *testing.T) {
 		})
 	}
 }
+
+type userFields struct {
+	Login string
+	Name  string
+}
+
+func (userFields) GetGraphQLFragment() (string, string) {
+	return "UserFields", "User"
+}
+
+type droidFields struct {
+	PrimaryFunction string
+	Friends         []droidFields `graphql_depth:"1"`
+}
+
+func (*droidFields) GetGraphQLFragment() (string, string) {
+	return "DroidFields", "Droid"
+}
+
+type otherUserFields struct {
+	ID ID
+}
+
+func (otherUserFields) GetGraphQLFragment() (string, string) {
+	return "UserFields", "User"
+}
+
+func TestConstructQuery_NamedFragments(t *testing.T) {
+	tests := []struct {
+		name      string
+		inV       any
+		variables map[string]any
+		want      string
+	}{
+		{
+			name: "fragment is defined once",
+			inV: struct {
+				Viewer   userFields
+				Owner    *userFields
+				Members  []userFields
+				Comments []struct {
+					Author userFields
+				}
+			}{},
+			want: `{viewer{...UserFields},owner{...UserFields},members{...UserFields},comments{author{...UserFields}}} fragment UserFields on User{login,name}`,
+		},
+		{
+			name: "embedded fragment is spread in the parent selection",
+			inV: struct {
+				Hero struct {
+					Typename string `graphql:"__typename"`
+					userFields
+					droidFields
+				} `graphql:"hero(episode: $ep)"`
+			}{},
+			variables: map[string]any{"ep": ID("1")},
+			want:      `query ($ep:ID!){hero(episode: $ep){__typename,...UserFields,...DroidFields}} fragment UserFields on User{login,name} fragment DroidFields on Droid{primaryFunction,friends{... on Droid{primaryFunction}}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ConstructQuery(tt.inV, tt.variables)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("\ngot:  %q\nwant: %q\n", got, tt.want)
+			}
+		})
+	}
+
+	_, err := ConstructQuery(struct {
+		Viewer userFields
+		Owner  otherUserFields
+	}{}, nil)
+	if err == nil || !strings.Contains(err.Error(), "fragment UserFields is defined by both") {
+		t.Errorf("got error: %v, want: fragment UserFields is defined by both types", err)
+	}
+}
+
+type nodeFields struct {
+	Name     string
+	Children []nodeFields `graphql_depth:"2"`
+}
+
+func (nodeFields) GetGraphQLFragment() (string, string) {
+	return "NodeFields", "Node"
+}
+
+type cyclicNodeFields struct {
+	Name     string
+	Children []cyclicNodeFields
+}
+
+func (cyclicNodeFields) GetGraphQLFragment() (string, string) {
+	return "CyclicNodeFields", "Node"
+}
+
+func TestConstructQuery_RecursiveFragments(t *testing.T) {
+	// fragment spreads can't form cycles, the recursive field is expanded up to its depth
+	got, err := ConstructQuery(struct {
+		Root  nodeFields
+		Trees []struct {
+			Node nodeFields
+		}
+	}{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{root{...NodeFields},trees{node{...NodeFields}}} fragment NodeFields on Node{name,children{... on Node{name,children{... on Node{name}}}}}`
+	if got != want {
+		t.Errorf("\ngot:  %q\nwant: %q\n", got, want)
+	}
+
+	_, err = ConstructQuery(struct {
+		Root cyclicNodeFields
+	}{}, nil)
+	if err == nil || !strings.Contains(err.Error(), "recursive type `graphql.cyclicNodeFields`, use the graphql_depth tag") {
+		t.Errorf("got error: %v, want recursive type error", err)
+	}
+}
+
+func TestConstructQuery_FieldDirectives(t *testing.T) {
+	var q struct {
+		User struct {
+			Name    string
+			Email   string `graphql:"email @skip(if: $anonymous)"`
+			Details *struct {
+				Bio string
+			} `graphql:"details: profile(size: $size) @include(if: $withDetails)"`
+		} `graphql:"user(id: $id)"`
+	}
+	variables := map[string]any{
+		"id":          ID("1"),
+		"size":        Int(10),
+		"anonymous":   Boolean(false),
+		"withDetails": true,
+	}
+
+	got, err := ConstructQuery(&q, variables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `query ($anonymous:Boolean!$id:ID!$size:Int!$withDetails:Boolean!){user(id: $id){name,email @skip(if: $anonymous),details: profile(size: $size) @include(if: $withDetails){bio}}}`
+	if got != want {
+		t.Errorf("\ngot:  %q\nwant: %q\n", got, want)
+	}
+}
+
+func TestConstructQuery_VariableValidation(t *testing.T) {
+	type repository struct {
+		Issue struct {
+			Title string `graphql:"title(format: \"$plain\")"`
+		} `graphql:"issue(number: $issueNumber)"`
+	}
+	var q struct {
+		Repository repository `graphql:"repository(owner: $owner)"`
+	}
+
+	tests := []struct {
+		name      string
+		variables any
+		options   []Option
+		wantErr   string
+	}{
+		{
+			name:      "declared and referenced",
+			variables: map[string]any{"owner": "o", "issueNumber": 1},
+		},
+		{
+			name: "struct variables",
+			variables: struct {
+				Owner       string `json:"owner"`
+				IssueNumber int    `json:"issueNumber"`
+			}{},
+		},
+		{
+			name:      "undeclared variable",
+			variables: map[string]any{"owner": "o"},
+			wantErr:   "struct field `Repository.Issue` references the undeclared variable $issueNumber",
+		},
+		{
+			name:    "no variables",
+			wantErr: "struct field `Repository` references the undeclared variable $owner",
+		},
+		{
+			name:      "unused variable",
+			variables: map[string]any{"owner": "o", "issueNumber": 1, "first": 10},
+			wantErr:   "variable $first is declared but not referenced by the query",
+		},
+		{
+			name:      "operation directive",
+			variables: map[string]any{"owner": "o", "issueNumber": 1},
+			options:   []Option{OperationDirective("cached", map[string]any{"ttl": Variable("ttl")})},
+			wantErr:   "operation directive @cached(ttl: $ttl) references the undeclared variable $ttl",
+		},
+		{
+			name:      "operation directive variable",
+			variables: map[string]any{"owner": "o", "issueNumber": 1, "ttl": 60},
+			options:   []Option{OperationDirective("cached", map[string]any{"ttl": Variable("ttl")})},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ConstructQuery(&q, tt.variables, tt.options...)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("got error: %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConstructQuery_RecursiveTypes(t *testing.T) {
+	type comment struct {
+		Body    string
+		Replies []*comment `graphql_depth:"2"`
+	}
+	type thread struct {
+		Comments []comment `graphql:"comments(first: 10)"`
+	}
+
+	got, err := ConstructQuery(&thread{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "{comments(first: 10){body,replies{body,replies{body}}}}"; got != want {
+		t.Errorf("got query: %v, want %v", got, want)
+	}
+
+	type node struct {
+		ID       string
+		Children []node
+	}
+	_, err = ConstructQuery(&node{}, nil)
+	if err == nil || !strings.Contains(err.Error(), "recursive type `graphql.node`, use the graphql_depth tag") {
+		t.Errorf("got error: %v, want recursive type error", err)
+	}
+
+	// ordered map selections are bounded by their values
+	type selection struct {
+		Name string
+		More [][2]any
+	}
+	got, err = ConstructQuery(&selection{More: [][2]any{
+		{"child", selection{More: [][2]any{}}},
+	}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "{name,more{child{name,more{}}}}"; got != want {
+		t.Errorf("got query: %v, want %v", got, want)
+	}
+
+	type mutualA struct {
+		Name string
+	}
+	type mutualB struct {
+		A  mutualA
+		A2 mutualA
+	}
+	// repeated sibling types aren't recursive
+	got, err = ConstructQuery(&mutualB{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "{a{name},a2{name}}"; got != want {
+		t.Errorf("got query: %v, want %v", got, want)
+	}
+
+	type invalid struct {
+		Body    string
+		Replies []*invalid `graphql_depth:"0"`
+	}
+	_, err = ConstructQuery(&invalid{}, nil)
+	if err == nil || !strings.Contains(err.Error(), "invalid graphql_depth tag \"0\" of struct field `Replies`") {
+		t.Errorf("got error: %v, want invalid tag error", err)
+	}
+}
+
+func TestConstructQuery_FieldMask(t *testing.T) {
+	type post struct {
+		Title string
+		Body  string
+	}
+	var q struct {
+		User struct {
+			Name  string
+			Email string
+			Posts []post `graphql:"posts(first: $first)"`
+		} `graphql:"user(id: $id)"`
+		Me struct {
+			Name string
+		} `graphql:"me: viewer"`
+	}
+
+	tests := []struct {
+		name      string
+		variables map[string]any
+		options   []Option
+		want      string
+		wantErr   string
+	}{
+		{
+			name:      "fields",
+			variables: map[string]any{"id": "1"},
+			options:   []Option{Fields("user.name", "me")},
+			want:      "query ($id:String!){user(id: $id){name},me: viewer{name}}",
+		},
+		{
+			name:      "nested fields",
+			variables: map[string]any{"id": "1", "first": 10},
+			options:   []Option{Fields("user.posts.title")},
+			want:      "query ($first:Int!$id:String!){user(id: $id){posts(first: $first){title}}}",
+		},
+		{
+			name:      "exclude",
+			variables: map[string]any{"id": "1"},
+			options:   []Option{Exclude("user.posts", "user.email")},
+			want:      "query ($id:String!){user(id: $id){name},me: viewer{name}}",
+		},
+		{
+			name:      "fields and exclude",
+			variables: map[string]any{"id": "1", "first": 10},
+			options:   []Option{Fields("user"), Exclude("user.posts.body")},
+			want:      "query ($first:Int!$id:String!){user(id: $id){name,email,posts(first: $first){title}}}",
+		},
+		{
+			name:      "variables of pruned fields are omitted",
+			variables: map[string]any{"id": "1", "first": 10},
+			options:   []Option{Exclude("user.posts")},
+			want:      "query ($id:String!){user(id: $id){name,email},me: viewer{name}}",
+		},
+		{
+			name:      "all variables omitted",
+			variables: map[string]any{"id": "1"},
+			options:   []Option{Fields("me")},
+			want:      "{me: viewer{name}}",
+		},
+		{
+			name:      "unknown field",
+			variables: map[string]any{"id": "1"},
+			options:   []Option{Fields("user.name", "user.age")},
+			wantErr:   `field path "user.age" doesn't match any field of the query`,
+		},
+		{
+			name:    "invalid path",
+			options: []Option{Exclude("user..name")},
+			wantErr: `invalid field path "user..name"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ConstructQuery(&q, tt.variables, tt.options...)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("got error: %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got query: %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConstructQuery_FieldMaskFragments(t *testing.T) {
+	type repository struct {
+		Owner userFields
+	}
+	var q struct {
+		Viewer     userFields
+		Repository repository
+	}
+
+	tests := []struct {
+		name    string
+		options []Option
+		want    string
+	}{
+		{
+			name:    "exclude",
+			options: []Option{Exclude("viewer.name")},
+			want:    `{viewer{... on User{login}},repository{owner{...UserFields}}} fragment UserFields on User{login,name}`,
+		},
+		{
+			name:    "fields",
+			options: []Option{Fields("viewer", "repository.owner.login")},
+			want:    `{viewer{...UserFields},repository{owner{... on User{login}}}} fragment UserFields on User{login,name}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ConstructQuery(&q, nil, tt.options...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("\ngot:  %q\nwant: %q\n", got, tt.want)
+			}
+		})
+	}
+}
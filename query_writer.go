@@ -6,23 +6,299 @@ import (
 	"fmt"
 	"io"
 	"reflect"
+	"strconv"
+	"strings"
 
 	"github.com/llehouerou/go-graphql-client/ident"
 	"github.com/llehouerou/go-graphql-client/internal/reflectutil"
+	"github.com/llehouerou/go-graphql-client/internal/tagparser"
 	"github.com/llehouerou/go-graphql-client/types"
 )
 
 // query uses writeQuery to recursively construct
 // a minified query string from the provided struct v.
+// The definitions of the named fragments are appended to the query.
+// The struct types registered in scalars aren't expanded, scalars may be nil.
+// The variables referenced by the graphql tags are returned with the query.
+// The struct fields pruned by mask are omitted, mask may be nil.
 //
 // E.g., struct{Foo Int, BarBaz *bool} -> "{foo,barBaz}".
-func query(v any) (string, error) {
+func query(v any, scalars *ScalarRegistry, mask *fieldMask) (string, *variableRefs, error) {
 	var buf bytes.Buffer
-	err := writeQuery(&buf, reflect.TypeOf(v), reflect.ValueOf(v), false)
+	fw := newFragmentWriter(&buf, scalars, mask)
+	err := writeQuery(fw, reflect.TypeOf(v), reflect.ValueOf(v), false)
 	if err != nil {
-		return "", fmt.Errorf("failed to write query: %w", err)
+		return "", nil, fmt.Errorf("failed to write query: %w", err)
 	}
-	return buf.String(), nil
+	if err := mask.validate(); err != nil {
+		return "", nil, err
+	}
+	for _, definition := range fw.fragments.definitions {
+		_, _ = io.WriteString(&buf, " "+definition)
+	}
+	return buf.String(), fw.refs, nil
+}
+
+// variableRefs collects the variables referenced by a query, with the location of their first reference
+type variableRefs struct {
+	// path holds the names of the struct fields being written
+	path []string
+	// locations maps the names of the variables to the location of their first reference
+	locations map[string]string
+	// names are in order of discovery
+	names []string
+	// pruned records the variables referenced by the struct fields pruned by the field mask
+	pruned map[string]bool
+}
+
+func newVariableRefs() *variableRefs {
+	return &variableRefs{
+		locations: make(map[string]string),
+		pruned:    make(map[string]bool),
+	}
+}
+
+// push enters the struct field name, and adds the variables referenced by its tag
+func (r *variableRefs) push(name string, tag string) {
+	r.path = append(r.path, name)
+	r.add(tag, fmt.Sprintf("struct field `%s`", strings.Join(r.path, ".")))
+}
+
+// pop leaves the current struct field
+func (r *variableRefs) pop() {
+	r.path = r.path[:len(r.path)-1]
+}
+
+// omitted returns the variables referenced only by the pruned struct fields
+func (r *variableRefs) omitted() map[string]bool {
+	omitted := make(map[string]bool)
+	for name := range r.pruned {
+		if _, ok := r.locations[name]; !ok {
+			omitted[name] = true
+		}
+	}
+	return omitted
+}
+
+// add adds the variables referenced by text at location
+func (r *variableRefs) add(text string, location string) {
+	for _, name := range tagparser.VariableReferences(text) {
+		if _, ok := r.locations[name]; !ok {
+			r.locations[name] = location
+			r.names = append(r.names, name)
+		}
+	}
+}
+
+// fragmentSet holds the named fragments used by a query
+type fragmentSet struct {
+	// types maps the fragment names to their struct types
+	types map[string]reflect.Type
+	// defining records the fragments whose definition is being written
+	defining map[string]bool
+	// definitions are in order of discovery
+	definitions []string
+}
+
+// fragmentWriter writes the query and collects the definitions of the named fragments.
+// Named fragments are inlined when the query is written to another io.Writer
+type fragmentWriter struct {
+	io.Writer
+	fragments *fragmentSet
+	scalars   *ScalarRegistry
+	refs      *variableRefs
+	recursion *recursionStack
+	mask      *fieldMask
+}
+
+func newFragmentWriter(
+	w io.Writer,
+	scalars *ScalarRegistry,
+	mask *fieldMask,
+) *fragmentWriter {
+	return &fragmentWriter{
+		Writer: w,
+		fragments: &fragmentSet{
+			types:    make(map[string]reflect.Type),
+			defining: make(map[string]bool),
+		},
+		scalars:   scalars,
+		refs:      newVariableRefs(),
+		recursion: &recursionStack{},
+		mask:      mask,
+	}
+}
+
+// recursionStack holds the struct types and the depth-limited struct fields being expanded,
+// to detect the recursive types and to limit their depth
+type recursionStack struct {
+	frames []recursionFrame
+}
+
+// recursionFrame is either a struct type, a depth-limited struct field,
+// or an interface or ordered map value when both are nil
+type recursionFrame struct {
+	structType reflect.Type
+	field      *depthLimitedField
+}
+
+// depthLimitedField identifies a struct field with the graphql_depth tag
+type depthLimitedField struct {
+	owner reflect.Type
+	index int
+}
+
+// enterStruct pushes the struct type t. It returns an error if t is already being expanded,
+// unless a depth-limited field or a value was entered since
+func (s *recursionStack) enterStruct(t reflect.Type) error {
+	for i := len(s.frames) - 1; i >= 0; i-- {
+		frame := s.frames[i]
+		if frame.structType == nil {
+			break
+		}
+		if frame.structType == t {
+			return fmt.Errorf(
+				"recursive type `%v`, use the %s tag to limit the depth of the recursive field",
+				t,
+				types.GraphQLDepthTag,
+			)
+		}
+	}
+	s.frames = append(s.frames, recursionFrame{structType: t})
+	return nil
+}
+
+// enterField pushes the depth-limited field. It returns false, without pushing the field,
+// if the field is already nested depth times
+func (s *recursionStack) enterField(field depthLimitedField, depth int) bool {
+	count := 0
+	for _, frame := range s.frames {
+		if frame.field != nil && *frame.field == field {
+			count++
+		}
+	}
+	if count >= depth {
+		return false
+	}
+	s.frames = append(s.frames, recursionFrame{field: &field})
+	return true
+}
+
+// enterValue pushes an interface or ordered map value. The query of such a value is bounded by the value,
+// so the struct types it contains may repeat the enclosing ones
+func (s *recursionStack) enterValue() {
+	s.frames = append(s.frames, recursionFrame{})
+}
+
+// leave pops the last struct type, field or value
+func (s *recursionStack) leave() {
+	s.frames = s.frames[:len(s.frames)-1]
+}
+
+// fieldDepth returns the maximum depth of the struct field f set by its graphql_depth tag, if any
+func fieldDepth(f reflect.StructField) (int, bool, error) {
+	tag, ok := f.Tag.Lookup(types.GraphQLDepthTag)
+	if !ok {
+		return 0, false, nil
+	}
+	depth, err := strconv.Atoi(strings.TrimSpace(tag))
+	if err != nil || depth < 1 {
+		return 0, false, fmt.Errorf(
+			"invalid %s tag %q of struct field `%s`, must be a positive integer",
+			types.GraphQLDepthTag,
+			tag,
+			f.Name,
+		)
+	}
+	return depth, true, nil
+}
+
+// writeFragmentSpread writes the spread of the named fragment t, and adds its definition on first use.
+// If inline is true, the spread is inlined into parent struct.
+func (fw *fragmentWriter) writeFragmentSpread(
+	t reflect.Type,
+	v reflect.Value,
+	name string,
+	typeCondition string,
+	inline bool,
+) error {
+	defined, ok := fw.fragments.types[name]
+	if ok && defined != t {
+		return fmt.Errorf(
+			"fragment %s is defined by both `%v` and `%v`",
+			name,
+			defined,
+			t,
+		)
+	}
+	// fragment spreads can't form cycles, a fragment used in its own definition is inlined
+	if fw.mask.prunes() || fw.fragments.defining[name] {
+		return fw.writeInlineFragment(t, v, name, typeCondition, inline)
+	}
+	if inline {
+		_, _ = io.WriteString(fw, "..."+name)
+	} else {
+		_, _ = io.WriteString(fw, "{..."+name+"}")
+	}
+	if ok {
+		return nil
+	}
+	fw.fragments.types[name] = t
+	fw.fragments.defining[name] = true
+	defer delete(fw.fragments.defining, name)
+
+	var body bytes.Buffer
+	err := writeStructFields(
+		&fragmentWriter{
+			Writer:    &body,
+			fragments: fw.fragments,
+			scalars:   fw.scalars,
+			refs:      fw.refs,
+			// the definition is shared by all the spreads, so its recursion doesn't depend on the first one
+			recursion: &recursionStack{frames: []recursionFrame{{structType: t}}},
+			mask:      fw.mask,
+		},
+		t,
+		v,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to write fragment %s: %w", name, err)
+	}
+	fw.fragments.definitions = append(
+		fw.fragments.definitions,
+		fmt.Sprintf("fragment %s on %s{%s}", name, typeCondition, body.String()),
+	)
+	return nil
+}
+
+// writeInlineFragment writes the named fragment t as an inline fragment, when the field mask prunes
+// its fields at this path, while the definition of the named fragment is shared by all its spreads,
+// or when t is spread in its own definition.
+// If inline is true, the inline fragment is inlined into parent struct.
+func (fw *fragmentWriter) writeInlineFragment(
+	t reflect.Type,
+	v reflect.Value,
+	name string,
+	typeCondition string,
+	inline bool,
+) error {
+	if err := fw.recursion.enterStruct(t); err != nil {
+		return err
+	}
+	defer fw.recursion.leave()
+
+	if !inline {
+		_, _ = io.WriteString(fw, "{")
+	}
+	_, _ = io.WriteString(fw, "... on "+typeCondition+"{")
+	if err := writeStructFields(fw, t, v); err != nil {
+		return fmt.Errorf("failed to write fragment %s: %w", name, err)
+	}
+	_, _ = io.WriteString(fw, "}")
+	if !inline {
+		_, _ = io.WriteString(fw, "}")
+	}
+	return nil
 }
 
 // fieldOutput contains the processed information for a struct field
@@ -123,6 +399,7 @@ func writeStructFields(
 	t reflect.Type,
 	v reflect.Value,
 ) error {
+	fw, _ := w.(*fragmentWriter)
 	iter := 0
 	for i := 0; i < t.NumField(); i++ {
 		f := t.Field(i)
@@ -134,6 +411,25 @@ func writeStructFields(
 			continue
 		}
 
+		// Skip this field if it's pruned by the field mask
+		pathName, hasPathName := fieldPathName(output)
+		if hasPathName && fw != nil && !fw.mask.selects(pathName) {
+			if err := fw.prune(f, fieldVal, output); err != nil {
+				return err
+			}
+			continue
+		}
+
+		depth, limited, err := fieldDepth(f)
+		if err != nil {
+			return err
+		}
+		// Skip this field if it's already nested up to its maximum depth
+		if limited && fw != nil &&
+			!fw.recursion.enterField(depthLimitedField{owner: t, index: i}, depth) {
+			continue
+		}
+
 		if iter != 0 {
 			_, _ = io.WriteString(w, ",")
 		}
@@ -142,12 +438,26 @@ func writeStructFields(
 		if !output.isInline {
 			_, _ = io.WriteString(w, output.name)
 		}
+
+		if fw != nil {
+			fw.refs.push(f.Name, output.name)
+			if hasPathName {
+				fw.mask.push(pathName)
+			}
+		}
 		// Skip writeQuery if the GraphQL type associated with the field is scalar
-		if output.isScalar {
-			continue
+		if !output.isScalar {
+			err = writeQuery(w, f.Type, fieldVal, output.isInline)
+		}
+		if fw != nil {
+			fw.refs.pop()
+			if hasPathName {
+				fw.mask.pop()
+			}
+			if limited {
+				fw.recursion.leave()
+			}
 		}
-
-		err := writeQuery(w, f.Type, fieldVal, output.isInline)
 		if err != nil {
 			return fmt.Errorf(
 				"failed to write query for struct field `%v`: %w",
@@ -159,6 +469,22 @@ func writeStructFields(
 	return nil
 }
 
+// prune adds the variables referenced by the struct field f pruned by the field mask,
+// and by its subfields, to the pruned variables
+func (fw *fragmentWriter) prune(f reflect.StructField, v reflect.Value, output fieldOutput) error {
+	pruned := newFragmentWriter(io.Discard, fw.scalars, nil)
+	pruned.refs.push(f.Name, output.name)
+	if !output.isScalar {
+		if err := writeQuery(pruned, f.Type, v, false); err != nil {
+			return fmt.Errorf("failed to write query for struct field `%v`: %w", f.Name, err)
+		}
+	}
+	for _, name := range pruned.refs.names {
+		fw.refs.pruned[name] = true
+	}
+	return nil
+}
+
 // writeStructQuery writes a minified query for a struct type to w.
 // If inline is true, the struct fields are inlined into parent struct.
 func writeStructQuery(
@@ -191,6 +517,19 @@ func writeStructQuery(
 	if isScalarType(t) {
 		return nil
 	}
+	fw, hasFragments := w.(*fragmentWriter)
+	if hasFragments && fw.scalars.isScalar(t) {
+		return nil
+	}
+	if name, typeCondition, ok := reflectutil.GetGraphQLFragment(t); ok && hasFragments {
+		return fw.writeFragmentSpread(t, v, name, typeCondition, inline)
+	}
+	if hasFragments {
+		if err := fw.recursion.enterStruct(t); err != nil {
+			return err
+		}
+		defer fw.recursion.leave()
+	}
 	if !inline {
 		_, _ = io.WriteString(w, "{")
 	}
@@ -249,7 +588,17 @@ func writeOrderedMapQuery(
 				val.Type(), key.Type(), val.Type())
 		}
 		_, _ = io.WriteString(w, keyString)
+		fw, _ := w.(*fragmentWriter)
+		if fw != nil {
+			fw.refs.push(keyString, keyString)
+			// the query of the pair is bounded by its value, like an interface value
+			fw.recursion.enterValue()
+		}
 		err := writeQuery(w, val.Type(), val, false)
+		if fw != nil {
+			fw.recursion.leave()
+			fw.refs.pop()
+		}
 		if err != nil {
 			return fmt.Errorf(
 				"failed to write query for pair[1] `%v`: %w",
@@ -280,6 +629,10 @@ func writeInterfaceQuery(
 		val.IsNil() {
 		return nil
 	}
+	if fw, ok := w.(*fragmentWriter); ok {
+		fw.recursion.enterValue()
+		defer fw.recursion.leave()
+	}
 	err := writeQuery(w, val.Type(), val, inline)
 	if err != nil {
 		return fmt.Errorf("failed to write query for interface `%v`: %w", t, err)
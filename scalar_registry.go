@@ -0,0 +1,396 @@
+package graphql
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/llehouerou/go-graphql-client/pkg/jsonutil"
+)
+
+// ScalarRegistry maps Go types to custom GraphQL scalars, e.g. time.Time to DateTime.
+//
+// The scalar name is the GraphQL type of the variables of the registered Go type,
+// instead of the name inferred from the Go type. The values of the registered types are
+// converted by the encode function of the scalar in the variables, and by its decode function
+// in the responses. Registered struct types aren't expanded into selection sets.
+//
+// Scalars must be registered before the registry is used by a client.
+type ScalarRegistry struct {
+	scalars  map[reflect.Type]scalarMapping
+	decoders map[reflect.Type]jsonutil.ScalarDecoder
+}
+
+type scalarMapping struct {
+	name   string
+	encode func(v reflect.Value) (any, error)
+}
+
+// NewScalarRegistry creates an empty scalar registry
+func NewScalarRegistry() *ScalarRegistry {
+	return &ScalarRegistry{
+		scalars:  make(map[reflect.Type]scalarMapping),
+		decoders: make(map[reflect.Type]jsonutil.ScalarDecoder),
+	}
+}
+
+// RegisterScalar maps the Go type T to the GraphQL scalar name in r, replacing the previous mapping of T.
+// If name is empty, the variables of type T keep the type inferred from the Go type.
+//
+// encode converts a value of T into the value sent in the variables, it may return any value
+// encoding/json can marshal. decode parses the JSON value of the scalar, it isn't called for null values.
+// encoding/json is used when encode or decode is nil.
+func RegisterScalar[T any](
+	r *ScalarRegistry,
+	name string,
+	encode func(value T) (any, error),
+	decode func(data []byte) (T, error),
+) {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+
+	mapping := scalarMapping{name: name}
+	if encode != nil {
+		mapping.encode = func(v reflect.Value) (any, error) {
+			value, err := encode(v.Interface().(T))
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode scalar %s: %w", scalarName(t, name), err)
+			}
+			return value, nil
+		}
+	}
+	if decode == nil {
+		decode = func(data []byte) (T, error) {
+			var value T
+			err := json.Unmarshal(data, &value)
+			return value, err
+		}
+	}
+
+	r.scalars[t] = mapping
+	r.decoders[t] = func(data []byte, v reflect.Value) error {
+		if bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		value, err := decode(data)
+		if err != nil {
+			return fmt.Errorf("failed to decode scalar %s: %w", scalarName(t, name), err)
+		}
+		v.Set(reflect.ValueOf(&value).Elem())
+		return nil
+	}
+}
+
+// scalarName returns the name of the scalar in the errors
+func scalarName(t reflect.Type, name string) string {
+	if name == "" {
+		return t.String()
+	}
+	return name
+}
+
+// UnmarshalGraphQL is like jsonutil.UnmarshalGraphQL, decoding the values of the registered types
+// with the decode function of their scalar. A nil registry decodes like jsonutil.UnmarshalGraphQL.
+func (r *ScalarRegistry) UnmarshalGraphQL(data []byte, v any) error {
+	if r == nil {
+		return jsonutil.UnmarshalGraphQL(data, v)
+	}
+	return jsonutil.UnmarshalGraphQLWithScalars(data, v, r.decoders)
+}
+
+// mapping returns the scalar registered for t
+func (r *ScalarRegistry) mapping(t reflect.Type) (scalarMapping, bool) {
+	if r == nil {
+		return scalarMapping{}, false
+	}
+	mapping, ok := r.scalars[t]
+	return mapping, ok
+}
+
+// isScalar reports whether t is registered
+func (r *ScalarRegistry) isScalar(t reflect.Type) bool {
+	_, ok := r.mapping(t)
+	return ok
+}
+
+// typeName returns the GraphQL type name of the registered type t
+func (r *ScalarRegistry) typeName(t reflect.Type) (string, bool) {
+	mapping, ok := r.mapping(t)
+	if !ok || mapping.name == "" {
+		return "", false
+	}
+	return mapping.name, true
+}
+
+// encodeVariables converts the values of the registered types in variables with the encode function
+// of their scalar, and omits the undefined Optional values of a variables map or struct. r may be nil.
+// variables is returned as is when there is nothing to encode.
+func (r *ScalarRegistry) encodeVariables(variables any) (any, error) {
+	if m, ok := variables.(map[string]any); ok {
+		variables = omitUndefinedVariables(m)
+	} else if undefined := undefinedFields(variables); len(undefined) > 0 {
+		omitted, err := omitVariables(variables, undefined, r)
+		if err != nil {
+			return nil, err
+		}
+		variables = omitted
+	}
+	if r == nil || len(r.scalars) == 0 || variables == nil {
+		return variables, nil
+	}
+	if !r.needsEncoding(reflect.TypeOf(variables), make(map[reflect.Type]bool)) {
+		return variables, nil
+	}
+	return r.encodeValue(reflect.ValueOf(variables))
+}
+
+// encodeVariableMap is encodeVariables for the map of variables of the subscription transports
+func (r *ScalarRegistry) encodeVariableMap(variables map[string]any) (map[string]any, error) {
+	encoded, err := r.encodeVariables(variables)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := encoded.(map[string]any)
+	return m, nil
+}
+
+// needsEncoding reports whether values of type t may contain a registered type with an encode function.
+// Interfaces and default values are inspected at runtime, and other types implementing json.Marshaler
+// are encoded by themselves
+func (r *ScalarRegistry) needsEncoding(t reflect.Type, visited map[reflect.Type]bool) bool {
+	if mapping, ok := r.mapping(t); ok {
+		return mapping.encode != nil
+	}
+	if visited[t] {
+		return false
+	}
+	visited[t] = true
+
+	if t.Implements(optionalType) {
+		return r.needsEncoding(reflect.Zero(t).Interface().(optional).elemType(), visited)
+	}
+	if t.Kind() == reflect.Interface || t == defaultedVariableType {
+		return true
+	}
+	if t.Implements(jsonMarshaler) {
+		return false
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		return r.needsEncoding(t.Elem(), visited)
+	case reflect.Map:
+		return t.Key().Kind() == reflect.String && r.needsEncoding(t.Elem(), visited)
+	case reflect.Struct:
+		if reflect.PointerTo(t).Implements(jsonMarshaler) {
+			return false
+		}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath == "" && r.needsEncoding(f.Type, visited) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// encodeValue converts v into a value encoding/json marshals like v,
+// with the values of the registered types converted by their scalar
+func (r *ScalarRegistry) encodeValue(v reflect.Value) (any, error) {
+	if !v.IsValid() {
+		return nil, nil
+	}
+	t := v.Type()
+	if mapping, ok := r.mapping(t); ok && mapping.encode != nil {
+		return mapping.encode(v)
+	}
+	if t.Implements(optionalType) {
+		value, ok := v.Interface().(optional).optionalValue()
+		if !ok {
+			return nil, nil
+		}
+		return r.encodeValue(reflect.ValueOf(value))
+	}
+	if t == defaultedVariableType {
+		return r.encodeValue(reflect.ValueOf(v.Interface().(DefaultedVariable).value))
+	}
+	if !r.needsEncoding(t, make(map[reflect.Type]bool)) {
+		return v.Interface(), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			return nil, nil
+		}
+		return r.encodeValue(v.Elem())
+	case reflect.Map:
+		if v.IsNil() {
+			return nil, nil
+		}
+		out := make(map[string]any, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			value, err := r.encodeValue(iter.Value())
+			if err != nil {
+				return nil, err
+			}
+			out[iter.Key().String()] = value
+		}
+		return out, nil
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil, nil
+		}
+		out := make([]any, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			value, err := r.encodeValue(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			out[i] = value
+		}
+		return out, nil
+	case reflect.Struct:
+		return r.encodeStruct(v)
+	}
+	return v.Interface(), nil
+}
+
+// encodeStruct marshals the struct v with encoding/json, and replaces the fields
+// which contain registered types with their converted value
+func (r *ScalarRegistry) encodeStruct(v reflect.Value) (any, error) {
+	data, err := json.Marshal(v.Interface())
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]any, len(fields))
+	for name, raw := range fields {
+		out[name] = raw
+	}
+	for name, fv := range jsonFieldValues(v) {
+		// the fields omitted by encoding/json stay omitted
+		if _, ok := out[name]; !ok ||
+			!r.needsEncoding(fv.Type(), make(map[reflect.Type]bool)) {
+			continue
+		}
+		value, err := r.encodeValue(fv)
+		if err != nil {
+			return nil, err
+		}
+		out[name] = value
+	}
+	return out, nil
+}
+
+// jsonFieldValues returns the values of the fields of the struct v by their JSON name.
+// The names of the promoted fields are resolved like encoding/json: the shallowest field wins,
+// then the tagged field, and conflicting fields are omitted.
+// The fields of unexported embedded structs are left to encoding/json
+func jsonFieldValues(v reflect.Value) map[string]reflect.Value {
+	type candidate struct {
+		value  reflect.Value
+		tagged bool
+	}
+
+	out := make(map[string]reflect.Value)
+	hidden := make(map[string]bool)
+	visited := make(map[reflect.Type]bool)
+	for level := []reflect.Value{v}; len(level) > 0; {
+		var next []reflect.Value
+		candidates := make(map[string][]candidate)
+		for _, sv := range level {
+			t := sv.Type()
+			if visited[t] {
+				continue
+			}
+			visited[t] = true
+
+			for i := 0; i < t.NumField(); i++ {
+				f := t.Field(i)
+				tag := f.Tag.Get("json")
+				if tag == "-" {
+					continue
+				}
+				name, _, _ := strings.Cut(tag, ",")
+				fv := sv.Field(i)
+
+				if f.Anonymous && name == "" {
+					ft := f.Type
+					if ft.Kind() == reflect.Ptr {
+						ft = ft.Elem()
+					}
+					if ft.Kind() == reflect.Struct {
+						if !f.IsExported() || fv.Kind() == reflect.Ptr && fv.IsNil() {
+							continue
+						}
+						next = append(next, reflect.Indirect(fv))
+						continue
+					}
+				}
+				if !f.IsExported() {
+					continue
+				}
+
+				tagged := name != ""
+				if !tagged {
+					name = f.Name
+				}
+				candidates[name] = append(candidates[name], candidate{value: fv, tagged: tagged})
+			}
+		}
+
+		for name, fields := range candidates {
+			if hidden[name] {
+				continue
+			}
+			hidden[name] = true
+			var dominant []candidate
+			for _, c := range fields {
+				if c.tagged {
+					dominant = append(dominant, c)
+				}
+			}
+			if len(dominant) == 0 {
+				dominant = fields
+			}
+			if len(dominant) == 1 {
+				out[name] = dominant[0].value
+			}
+		}
+		level = next
+	}
+	return out
+}
+
+// WithScalars returns a new Client using the scalar registry for the types of the variables,
+// their encoding and the decoding of the responses.
+//
+// This method follows an immutable pattern: it returns a NEW Client instance
+// without modifying the original. You must use the returned Client:
+//
+//	client = client.WithScalars(registry)  // Correct
+//	client.WithScalars(registry)            // Wrong - has no effect
+func (c *Client) WithScalars(scalars *ScalarRegistry) *Client {
+	clone := c.clone()
+	clone.scalars = scalars
+	return clone
+}
+
+// WithScalars sets the scalar registry used for the types of the variables, their encoding
+// and the decoding of the results of Query and Mutate.
+// The subscription handlers receive raw messages, they may decode them with the UnmarshalGraphQL method of the registry
+func (sc *SubscriptionClient) WithScalars(scalars *ScalarRegistry) *SubscriptionClient {
+	sc.scalars = scalars
+	return sc
+}
+
+var jsonMarshaler = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
@@ -0,0 +1,204 @@
+package graphql_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/llehouerou/go-graphql-client"
+)
+
+type money struct {
+	Cents    int64
+	Currency string
+}
+
+func newTestScalarRegistry() *graphql.ScalarRegistry {
+	scalars := graphql.NewScalarRegistry()
+	graphql.RegisterScalar(scalars, "Date",
+		func(value time.Time) (any, error) {
+			return value.Format(time.DateOnly), nil
+		},
+		func(data []byte) (time.Time, error) {
+			var s string
+			if err := json.Unmarshal(data, &s); err != nil {
+				return time.Time{}, err
+			}
+			return time.Parse(time.DateOnly, s)
+		},
+	)
+	graphql.RegisterScalar(scalars, "Money",
+		func(value money) (any, error) {
+			return fmt.Sprintf("%d %s", value.Cents, value.Currency), nil
+		},
+		func(data []byte) (money, error) {
+			var s string
+			if err := json.Unmarshal(data, &s); err != nil {
+				return money{}, err
+			}
+			var m money
+			_, err := fmt.Sscanf(s, "%d %s", &m.Cents, &m.Currency)
+			return m, err
+		},
+	)
+	graphql.RegisterScalar[int64](scalars, "Long", nil, nil)
+	return scalars
+}
+
+func TestClient_WithScalars(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/graphql", func(w http.ResponseWriter, req *http.Request) {
+		body := mustRead(req.Body)
+		if got, want := body, `{"query":"query ($after:Date!$limit:Long$prices:[Money!]!){orders(after: $after, limit: $limit, prices: $prices){total,date,history,count}}","variables":{"after":"2024-03-01","limit":10,"prices":["150 EUR"]}}`+"\n"; got != want {
+			t.Errorf("got body: %v, want %v", got, want)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		mustWrite(w, `{"data": {"orders": {"total": "1250 EUR", "date": "2024-03-02", "history": ["2024-03-03", null], "count": 3}}}`)
+	})
+	client := graphql.NewClient(
+		"/graphql",
+		&http.Client{Transport: localRoundTripper{handler: mux}},
+	).WithScalars(newTestScalarRegistry())
+
+	var q struct {
+		Orders struct {
+			Total   money
+			Date    *time.Time
+			History []*time.Time
+			Count   int64
+		} `graphql:"orders(after: $after, limit: $limit, prices: $prices)"`
+	}
+	limit := int64(10)
+	variables := map[string]any{
+		"after":  time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		"limit":  &limit,
+		"prices": []money{{Cents: 150, Currency: "EUR"}},
+	}
+	if err := client.Query(context.Background(), &q, variables); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := q.Orders.Total, (money{Cents: 1250, Currency: "EUR"}); got != want {
+		t.Errorf("got total: %v, want %v", got, want)
+	}
+	if q.Orders.Date == nil || q.Orders.Date.Format(time.DateOnly) != "2024-03-02" {
+		t.Errorf("got date: %v, want 2024-03-02", q.Orders.Date)
+	}
+	if len(q.Orders.History) != 2 || q.Orders.History[0].Format(time.DateOnly) != "2024-03-03" ||
+		q.Orders.History[1] != nil {
+		t.Errorf("got history: %v", q.Orders.History)
+	}
+	if q.Orders.Count != 3 {
+		t.Errorf("got count: %d, want 3", q.Orders.Count)
+	}
+}
+
+func TestClient_WithScalars_structVariables(t *testing.T) {
+	type orderFilter struct {
+		Before time.Time  `json:"before"`
+		After  *time.Time `json:"after,omitempty"`
+		Status string     `json:"status"`
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/graphql", func(w http.ResponseWriter, req *http.Request) {
+		body := mustRead(req.Body)
+		if got, want := body, `{"query":"query ($before:Date!$status:String!){orders(before: $before, status: $status){count}}","variables":{"before":"2024-03-01","status":"open"}}`+"\n"; got != want {
+			t.Errorf("got body: %v, want %v", got, want)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		mustWrite(w, `{"data": {"orders": {"count": 1}}}`)
+	})
+	client := graphql.NewClient(
+		"/graphql",
+		&http.Client{Transport: localRoundTripper{handler: mux}},
+	).WithScalars(newTestScalarRegistry())
+
+	var q struct {
+		Orders struct {
+			Count int
+		} `graphql:"orders(before: $before, status: $status)"`
+	}
+	variables := struct {
+		Before time.Time `json:"before"`
+		Status string    `json:"status"`
+	}{
+		Before: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		Status: "open",
+	}
+	if err := client.Query(context.Background(), &q, variables); err != nil {
+		t.Fatal(err)
+	}
+
+	// nested input objects are encoded with their json tags
+	filter := orderFilter{Before: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), Status: "open"}
+	req, _, err := client.BuildRequest(context.Background(), "query{x}", map[string]any{"filter": filter})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := mustRead(req.Body), `{"query":"query{x}","variables":{"filter":{"before":"2024-03-01","status":"open"}}}`+"\n"; got != want {
+		t.Errorf("got body: %v, want %v", got, want)
+	}
+}
+
+func TestScalarRegistry_errors(t *testing.T) {
+	scalars := graphql.NewScalarRegistry()
+	graphql.RegisterScalar(scalars, "Even",
+		func(value int) (any, error) {
+			if value%2 != 0 {
+				return nil, fmt.Errorf("%d is odd", value)
+			}
+			return value, nil
+		},
+		nil,
+	)
+	client := graphql.NewClient("/graphql", nil).WithScalars(scalars)
+
+	_, _, err := client.BuildRequest(context.Background(), "query{x}", map[string]any{"n": 3})
+	if err == nil || !strings.Contains(err.Error(), "failed to encode scalar Even: 3 is odd") {
+		t.Errorf("got error: %v, want encode error", err)
+	}
+
+	var v struct{ N int }
+	err = scalars.UnmarshalGraphQL([]byte(`{"n": "x"}`), &v)
+	if err == nil || !strings.Contains(err.Error(), "failed to decode scalar Even") {
+		t.Errorf("got error: %v, want decode error", err)
+	}
+}
+
+func TestScalarRegistry_structJSONOptions(t *testing.T) {
+	type Audit struct {
+		Created time.Time `json:"created"`
+		Status  string    `json:"status"`
+	}
+	type orderInput struct {
+		Audit
+		Due    time.Time  `json:"due"`
+		Paid   *time.Time `json:"paid,omitempty"`
+		Total  money      `json:"total,omitzero"`
+		N      int        `json:"n,string"`
+		Skip   int        `json:"skip,omitzero"`
+		Status string     `json:"status"`
+		Extra  any        `json:"extra"`
+	}
+	input := orderInput{
+		Audit:  Audit{Created: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), Status: "hidden"},
+		Due:    time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC),
+		N:      5,
+		Status: "open",
+	}
+	client := graphql.NewClient("/graphql", nil).WithScalars(newTestScalarRegistry())
+
+	req, _, err := client.BuildRequest(context.Background(), "query{x}", map[string]any{"in": input})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the outer status field hides the promoted one
+	if got, want := mustRead(req.Body), `{"query":"query{x}","variables":{"in":{"created":"2024-03-01","due":"2024-03-02","extra":null,"n":"5","status":"open"}}}`+"\n"; got != want {
+		t.Errorf("got body: %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,645 @@
+package graphql
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// SSE transport follows the graphql-sse protocol specification
+// https://github.com/enisdenjo/graphql-sse/blob/master/PROTOCOL.md
+
+const (
+	// SSETokenHeader is the header carrying the event stream token in the single connection mode
+	SSETokenHeader = "X-GraphQL-Event-Stream-Token"
+
+	sseEventNext     = "next"
+	sseEventComplete = "complete"
+)
+
+// SSEMode is the connection mode of the graphql-sse protocol
+type SSEMode int
+
+const (
+	// SSEDistinctConnections opens one HTTP connection per subscription. This is the default
+	SSEDistinctConnections SSEMode = iota
+	// SSESingleConnection streams the results of all subscriptions over one reserved event stream
+	SSESingleConnection
+)
+
+// Subscriber is implemented by the subscription transports: SubscriptionClient over websocket
+// SSEClient over Server-Sent Events and MultipartClient over multipart HTTP responses
+type Subscriber interface {
+	// Subscribe starts a subscription derived from v, and returns its ID
+	Subscribe(
+		v any,
+		variables map[string]any,
+		handler func(message []byte, err error) error,
+		options ...Option,
+	) (string, error)
+	// Exec starts a subscription with a pre-built query, and returns its ID
+	Exec(
+		query string,
+		variables map[string]any,
+		handler func(message []byte, err error) error,
+	) (string, error)
+	// Unsubscribe stops the subscription
+	Unsubscribe(id string) error
+	// Close stops all subscriptions and releases the connections
+	Close() error
+}
+
+var (
+	_ Subscriber = (*SubscriptionClient)(nil)
+	_ Subscriber = (*SSEClient)(nil)
+	_ Subscriber = (*MultipartClient)(nil)
+)
+
+type sseSubscription struct {
+	handler func(data []byte, err error) error
+	cancel  context.CancelFunc
+}
+
+// SSEClient is a GraphQL subscription client using the graphql-sse protocol over HTTP.
+// It's useful when websocket upgrades aren't allowed by the network.
+//
+// Requests are sent with the http.Client, the request modifier and the debug mode of the Client.
+// The http.Client shouldn't have a timeout, because it would close long running subscriptions.
+//
+// Subscriptions start when Subscribe is called, there is no Run method.
+// The With* and On* methods configure the client and must be called before Subscribe.
+// Other methods are safe for concurrent use.
+type SSEClient struct {
+	client  *Client
+	mode    SSEMode
+	onError func(sc *SSEClient, err error)
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu            sync.Mutex
+	subscriptions map[string]*sseSubscription
+	closed        bool
+
+	// streamMu serializes the opening of the event stream in the single connection mode
+	streamMu     sync.Mutex
+	token        string
+	streamCancel context.CancelFunc
+}
+
+// NewSSEClient creates a graphql-sse subscription client, sending requests to the URL of client
+func NewSSEClient(client *Client) *SSEClient {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &SSEClient{
+		client:        client,
+		ctx:           ctx,
+		cancel:        cancel,
+		subscriptions: make(map[string]*sseSubscription),
+	}
+}
+
+// WithMode sets the connection mode of the graphql-sse protocol. Default SSEDistinctConnections
+func (sc *SSEClient) WithMode(mode SSEMode) *SSEClient {
+	sc.mode = mode
+	return sc
+}
+
+// OnError event is triggered when a subscription handler returns an error,
+// or when the event stream of the single connection mode fails
+func (sc *SSEClient) OnError(onError func(sc *SSEClient, err error)) *SSEClient {
+	sc.onError = onError
+	return sc
+}
+
+// Subscribe starts a subscription derived from v.
+// The handler receives the data of every result, or the error of the subscription.
+// Returning a non-nil error from the handler stops the subscription. Unless it's ErrSubscriptionStopped,
+// the error is also reported to the OnError event
+func (sc *SSEClient) Subscribe(
+	v any,
+	variables map[string]any,
+	handler func(message []byte, err error) error,
+	options ...Option,
+) (string, error) {
+	query, omitted, err := constructOperation(
+		"subscription",
+		v,
+		variables,
+		true,
+		sc.client.scalars,
+		options...,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	return sc.Exec(query, omitVariableMap(variables, omitted), handler)
+}
+
+// Exec starts a subscription with a pre-built query
+func (sc *SSEClient) Exec(
+	query string,
+	variables map[string]any,
+	handler func(message []byte, err error) error,
+) (string, error) {
+	variables, err := sc.client.scalars.encodeVariableMap(variables)
+	if err != nil {
+		return "", err
+	}
+	id := uuid.New().String()
+	ctx, cancel := context.WithCancel(sc.ctx)
+	sub := &sseSubscription{
+		handler: handler,
+		cancel:  cancel,
+	}
+
+	sc.mu.Lock()
+	if sc.closed {
+		sc.mu.Unlock()
+		cancel()
+		return "", errors.New("sse client is closed")
+	}
+	sc.subscriptions[id] = sub
+	sc.mu.Unlock()
+
+	if sc.mode == SSESingleConnection {
+		if err := sc.execSingle(ctx, id, query, variables); err != nil {
+			sc.remove(id)
+			return "", err
+		}
+		return id, nil
+	}
+
+	go sc.runDistinct(ctx, id, sub, query, variables)
+
+	return id, nil
+}
+
+// Unsubscribe stops the subscription
+func (sc *SSEClient) Unsubscribe(id string) error {
+	sub := sc.remove(id)
+	if sub == nil {
+		return fmt.Errorf("subscription id %s doesn't not exist", id)
+	}
+
+	if sc.mode == SSESingleConnection {
+		return sc.cancelSingle(id)
+	}
+
+	return nil
+}
+
+// Close stops all subscriptions and closes the connections
+func (sc *SSEClient) Close() error {
+	sc.mu.Lock()
+	sc.closed = true
+	for id, sub := range sc.subscriptions {
+		sub.cancel()
+		delete(sc.subscriptions, id)
+	}
+	sc.mu.Unlock()
+
+	sc.cancel()
+
+	sc.streamMu.Lock()
+	sc.closeStream()
+	sc.streamMu.Unlock()
+
+	return nil
+}
+
+// remove deletes the subscription and cancels its context. It returns nil if the subscription doesn't exist
+func (sc *SSEClient) remove(id string) *sseSubscription {
+	sc.mu.Lock()
+	sub, ok := sc.subscriptions[id]
+	delete(sc.subscriptions, id)
+	sc.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+	sub.cancel()
+	return sub
+}
+
+func (sc *SSEClient) getSubscription(id string) (*sseSubscription, bool) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sub, ok := sc.subscriptions[id]
+	return sub, ok
+}
+
+// handle calls the subscription handler.
+// It returns false if the handler stopped the subscription
+func (sc *SSEClient) handle(id string, sub *sseSubscription, data []byte, err error) bool {
+	errValue := sub.handler(data, err)
+	if errValue == nil {
+		return true
+	}
+
+	_ = sc.Unsubscribe(id)
+	if !errors.Is(errValue, ErrSubscriptionStopped) {
+		sc.reportError(errValue)
+	}
+	return false
+}
+
+// handleResult decodes the execution result and calls the subscription handler
+func (sc *SSEClient) handleResult(id string, sub *sseSubscription, payload []byte) bool {
+	data, errs := sc.client.DecodeResponse(bytes.NewReader(payload))
+	if len(errs) > 0 {
+		return sc.handle(id, sub, nil, errs)
+	}
+	return sc.handle(id, sub, data, nil)
+}
+
+func (sc *SSEClient) reportError(err error) {
+	if sc.onError != nil {
+		sc.onError(sc, err)
+	}
+}
+
+// runDistinct streams the results of the subscription over its own connection, until it's completed
+func (sc *SSEClient) runDistinct(
+	ctx context.Context,
+	id string,
+	sub *sseSubscription,
+	query string,
+	variables map[string]any,
+) {
+	defer sc.remove(id)
+
+	req, reqBody, err := sc.client.newStreamRequest(ctx, http.MethodPost, nil, streamRequest{
+		Query:     query,
+		Variables: variables,
+	})
+	if err != nil {
+		sc.handle(id, sub, nil, Errors{sc.client.NewRequestError(
+			ErrRequestError,
+			fmt.Errorf("problem constructing request: %w", err),
+			req,
+			nil,
+			bytes.NewReader(reqBody),
+			nil,
+		)})
+		return
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := sc.client.httpClient.Do(req)
+	if err != nil {
+		if ctx.Err() == nil {
+			sc.handle(id, sub, nil, Errors{sc.client.NewRequestError(
+				ErrRequestError,
+				err,
+				req,
+				nil,
+				bytes.NewReader(reqBody),
+				nil,
+			)})
+		}
+		return
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if errs := sc.client.checkStreamResponse(resp, http.StatusOK, req, reqBody); len(errs) > 0 {
+		sc.handle(id, sub, nil, errs)
+		return
+	}
+
+	// the server may execute the operation as a single result instead of a stream
+	if !isEventStream(resp) {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			sc.handle(id, sub, nil, newSimpleErrors(ErrJsonDecode, err))
+			return
+		}
+		sc.handleResult(id, sub, body)
+		return
+	}
+
+	events := newSSEReader(resp.Body)
+	for {
+		event, err := events.next()
+		if err != nil {
+			if ctx.Err() == nil {
+				sc.handle(id, sub, nil, Errors{sc.client.NewRequestError(
+					ErrRequestError,
+					fmt.Errorf("event stream closed: %w", err),
+					req,
+					resp,
+					bytes.NewReader(reqBody),
+					nil,
+				)})
+			}
+			return
+		}
+
+		switch event.name {
+		case sseEventNext:
+			if !sc.handleResult(id, sub, event.data) {
+				return
+			}
+		case sseEventComplete:
+			return
+		}
+	}
+}
+
+// execSingle sends the operation to the event stream of the single connection mode, opening it if needed
+func (sc *SSEClient) execSingle(
+	ctx context.Context,
+	id string,
+	query string,
+	variables map[string]any,
+) error {
+	token, err := sc.openStream()
+	if err != nil {
+		return err
+	}
+
+	req, reqBody, err := sc.client.newStreamRequest(ctx, http.MethodPost, sseTokenHeader(token), streamRequest{
+		Query:      query,
+		Variables:  variables,
+		Extensions: map[string]any{"operationId": id},
+	})
+	if err != nil {
+		return Errors{sc.client.NewRequestError(
+			ErrRequestError,
+			fmt.Errorf("problem constructing request: %w", err),
+			req,
+			nil,
+			bytes.NewReader(reqBody),
+			nil,
+		)}
+	}
+
+	return sc.doSingle(req, reqBody, http.StatusAccepted)
+}
+
+// cancelSingle stops the operation in the single connection mode
+func (sc *SSEClient) cancelSingle(id string) error {
+	sc.streamMu.Lock()
+	token := sc.token
+	sc.streamMu.Unlock()
+	if token == "" {
+		return nil
+	}
+
+	req, reqBody, err := sc.client.newStreamRequest(sc.ctx, http.MethodDelete, sseTokenHeader(token), nil)
+	if err != nil {
+		return err
+	}
+	query := req.URL.Query()
+	query.Set("operationId", id)
+	req.URL.RawQuery = query.Encode()
+
+	return sc.doSingle(req, reqBody, http.StatusOK)
+}
+
+// doSingle executes a request of the single connection mode, which doesn't return a result
+func (sc *SSEClient) doSingle(req *http.Request, reqBody []byte, status int) error {
+	resp, err := sc.client.httpClient.Do(req)
+	if err != nil {
+		return Errors{sc.client.NewRequestError(
+			ErrRequestError,
+			err,
+			req,
+			nil,
+			bytes.NewReader(reqBody),
+			nil,
+		)}
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if errs := sc.client.checkStreamResponse(resp, status, req, reqBody); len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// openStream reserves the event stream and connects to it, if it isn't open yet.
+// It returns the token of the stream
+func (sc *SSEClient) openStream() (string, error) {
+	sc.streamMu.Lock()
+	defer sc.streamMu.Unlock()
+
+	if sc.token != "" {
+		return sc.token, nil
+	}
+
+	token, err := sc.reserveStream()
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithCancel(sc.ctx)
+	req, reqBody, err := sc.client.newStreamRequest(ctx, http.MethodGet, sseTokenHeader(token), nil)
+	if err != nil {
+		cancel()
+		return "", err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := sc.client.httpClient.Do(req)
+	if err != nil {
+		cancel()
+		return "", Errors{sc.client.NewRequestError(
+			ErrRequestError,
+			err,
+			req,
+			nil,
+			bytes.NewReader(reqBody),
+			nil,
+		)}
+	}
+	if errs := sc.client.checkStreamResponse(resp, http.StatusOK, req, reqBody); len(errs) > 0 {
+		_ = resp.Body.Close()
+		cancel()
+		return "", errs
+	}
+
+	sc.token = token
+	sc.streamCancel = cancel
+	go sc.readStream(ctx, token, resp)
+
+	return token, nil
+}
+
+// reserveStream requests the token of a new event stream
+func (sc *SSEClient) reserveStream() (string, error) {
+	req, reqBody, err := sc.client.newStreamRequest(sc.ctx, http.MethodPut, nil, nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := sc.client.httpClient.Do(req)
+	if err != nil {
+		return "", Errors{sc.client.NewRequestError(
+			ErrRequestError,
+			err,
+			req,
+			nil,
+			bytes.NewReader(reqBody),
+			nil,
+		)}
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if errs := sc.client.checkStreamResponse(resp, http.StatusCreated, req, reqBody); len(errs) > 0 {
+		return "", errs
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", newSimpleErrors(ErrRequestError, err)
+	}
+	token := strings.TrimSpace(string(body))
+	if token == "" {
+		return "", newSimpleErrors(ErrRequestError, errors.New("empty event stream token"))
+	}
+
+	return token, nil
+}
+
+// readStream dispatches the events of the single connection stream to the subscriptions.
+// When the stream fails, the error is sent to all subscriptions, which are removed
+func (sc *SSEClient) readStream(ctx context.Context, token string, resp *http.Response) {
+	defer func() { _ = resp.Body.Close() }()
+
+	events := newSSEReader(resp.Body)
+	for {
+		event, err := events.next()
+		if err != nil {
+			if ctx.Err() == nil {
+				sc.failStream(token, newSimpleErrors(
+					ErrRequestError,
+					fmt.Errorf("event stream closed: %w", err),
+				))
+			}
+			return
+		}
+
+		var msg struct {
+			ID      string          `json:"id"`
+			Payload json.RawMessage `json:"payload"`
+		}
+		if len(event.data) == 0 || json.Unmarshal(event.data, &msg) != nil {
+			continue
+		}
+
+		sub, ok := sc.getSubscription(msg.ID)
+		if !ok {
+			continue
+		}
+
+		switch event.name {
+		case sseEventNext:
+			sc.handleResult(msg.ID, sub, msg.Payload)
+		case sseEventComplete:
+			sc.remove(msg.ID)
+		}
+	}
+}
+
+// failStream closes the broken stream and notifies the subscriptions
+func (sc *SSEClient) failStream(token string, err error) {
+	sc.streamMu.Lock()
+	if sc.token == token {
+		sc.closeStream()
+	}
+	sc.streamMu.Unlock()
+
+	sc.mu.Lock()
+	subs := sc.subscriptions
+	sc.subscriptions = make(map[string]*sseSubscription)
+	sc.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.cancel()
+		_ = sub.handler(nil, err)
+	}
+	sc.reportError(err)
+}
+
+// closeStream cancels the event stream of the single connection mode. streamMu must be held
+func (sc *SSEClient) closeStream() {
+	if sc.streamCancel != nil {
+		sc.streamCancel()
+	}
+	sc.token = ""
+	sc.streamCancel = nil
+}
+
+func sseTokenHeader(token string) http.Header {
+	return http.Header{SSETokenHeader: []string{token}}
+}
+
+func isEventStream(resp *http.Response) bool {
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return err == nil && mediaType == "text/event-stream"
+}
+
+// sseEvent is an event of a Server-Sent Events stream
+type sseEvent struct {
+	name string
+	data []byte
+}
+
+// sseReader parses the events of a Server-Sent Events stream
+// https://html.spec.whatwg.org/multipage/server-sent-events.html#event-stream-interpretation
+type sseReader struct {
+	r *bufio.Reader
+}
+
+func newSSEReader(r io.Reader) *sseReader {
+	return &sseReader{r: bufio.NewReader(r)}
+}
+
+// next returns the next event of the stream. Comments, used as keep-alive, are skipped
+func (sr *sseReader) next() (sseEvent, error) {
+	var event sseEvent
+	var data [][]byte
+	for {
+		line, err := sr.r.ReadBytes('\n')
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return sseEvent{}, err
+		}
+		line = bytes.TrimRight(line, "\r\n")
+
+		// an empty line dispatches the event
+		if len(line) == 0 {
+			if event.name == "" && data == nil {
+				continue
+			}
+			event.data = bytes.Join(data, []byte("\n"))
+			return event, nil
+		}
+
+		if line[0] == ':' {
+			continue
+		}
+
+		field, value, _ := bytes.Cut(line, []byte(":"))
+		value = bytes.TrimPrefix(value, []byte(" "))
+		switch string(field) {
+		case "event":
+			event.name = string(value)
+		case "data":
+			data = append(data, append([]byte(nil), value...))
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package graphql
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type sseTestRequest struct {
+	Query      string         `json:"query"`
+	Variables  map[string]any `json:"variables"`
+	Extensions map[string]any `json:"extensions"`
+}
+
+func writeSSEEvent(w http.ResponseWriter, event string, data string) {
+	_, _ = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	w.(http.Flusher).Flush()
+}
+
+func TestSSEClient_DistinctConnections(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Accept") != "text/event-stream" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var req sseTestRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if req.Query != `subscription ($id:ID!){count(id: $id)}` {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = io.WriteString(w, `{"errors":[{"message":"invalid query"}]}`)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, ": keep-alive\n\n")
+		for i := 1; i <= 2; i++ {
+			writeSSEEvent(w, "next", fmt.Sprintf(`{"data":{"count":%d,"id":"%s"}}`, i, req.Variables["id"]))
+		}
+		writeSSEEvent(w, "complete", "")
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, nil).WithRequestModifier(func(r *http.Request) {
+		r.Header.Set("Authorization", "Bearer token")
+	})
+	sseClient := NewSSEClient(client)
+	defer func() { _ = sseClient.Close() }()
+
+	var sub struct {
+		Count int    `graphql:"count(id: $id)"`
+		ID    string `graphql:"-"`
+	}
+	messages := make(chan string, 10)
+	_, err := sseClient.Subscribe(&sub, map[string]any{"id": ID("1")}, func(message []byte, err error) error {
+		if err != nil {
+			t.Errorf("got error: %v, want: nil", err)
+			return nil
+		}
+		messages <- string(message)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("got error: %v, want: nil", err)
+	}
+
+	for _, want := range []string{`{"count":1,"id":"1"}`, `{"count":2,"id":"1"}`} {
+		select {
+		case got := <-messages:
+			if got != want {
+				t.Errorf("got message: %s, want: %s", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("message wasn't received")
+		}
+	}
+
+	// the subscription is removed once completed
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		sseClient.mu.Lock()
+		n := len(sseClient.subscriptions)
+		sseClient.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d subscriptions, want: 0", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// GraphQL errors of a rejected operation are sent to the handler
+	errs := make(chan error, 1)
+	_, err = sseClient.Exec(`subscription { invalid }`, nil, func(message []byte, err error) error {
+		errs <- err
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("got error: %v, want: nil", err)
+	}
+	select {
+	case err := <-errs:
+		var gqlErrs Errors
+		if !errors.As(err, &gqlErrs) || gqlErrs[0].Message != "invalid query" {
+			t.Errorf("got error: %v, want: invalid query", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("error wasn't received")
+	}
+}
+
+func TestSSEClient_StopFromHandler(t *testing.T) {
+	disconnected := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				close(disconnected)
+				return
+			case <-ticker.C:
+				writeSSEEvent(w, "next", `{"data":{"tick":true}}`)
+			}
+		}
+	}))
+	defer server.Close()
+
+	reported := make(chan error, 1)
+	sseClient := NewSSEClient(NewClient(server.URL, nil)).
+		OnError(func(sc *SSEClient, err error) {
+			reported <- err
+		})
+	defer func() { _ = sseClient.Close() }()
+
+	_, err := sseClient.Exec(`subscription { tick }`, nil, func(message []byte, err error) error {
+		return ErrSubscriptionStopped
+	})
+	if err != nil {
+		t.Fatalf("got error: %v, want: nil", err)
+	}
+
+	select {
+	case <-disconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the connection wasn't closed")
+	}
+	select {
+	case err := <-reported:
+		t.Errorf("got reported error: %v, want: nil", err)
+	default:
+	}
+}
+
+// sseSingleConnectionServer implements the single connection mode of the graphql-sse protocol
+type sseSingleConnectionServer struct {
+	mu        sync.Mutex
+	stream    chan string
+	cancelled []string
+}
+
+func (s *sseSingleConnectionServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut {
+		w.WriteHeader(http.StatusCreated)
+		_, _ = io.WriteString(w, "stream-token")
+		return
+	}
+	if r.Header.Get(SSETokenHeader) != "stream-token" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case event := <-s.stream:
+				_, _ = io.WriteString(w, event)
+				w.(http.Flusher).Flush()
+			}
+		}
+	case http.MethodPost:
+		var req sseTestRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		id, _ := req.Extensions["operationId"].(string)
+		w.WriteHeader(http.StatusAccepted)
+		go func() {
+			s.stream <- fmt.Sprintf("event: next\ndata: {\"id\":\"%s\",\"payload\":{\"data\":{\"value\":\"%s\"}}}\n\n", id, req.Variables["value"])
+		}()
+	case http.MethodDelete:
+		s.mu.Lock()
+		s.cancelled = append(s.cancelled, r.URL.Query().Get("operationId"))
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func TestSSEClient_SingleConnection(t *testing.T) {
+	handler := &sseSingleConnectionServer{stream: make(chan string)}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	sseClient := NewSSEClient(NewClient(server.URL, nil)).WithMode(SSESingleConnection)
+	defer func() { _ = sseClient.Close() }()
+
+	messages := make(chan string, 10)
+	var ids []string
+	for _, value := range []string{"a", "b"} {
+		id, err := sseClient.Exec(`subscription ($value: String!) { value(v: $value) }`, map[string]any{"value": value}, func(message []byte, err error) error {
+			if err != nil {
+				t.Errorf("got error: %v, want: nil", err)
+				return nil
+			}
+			messages <- string(message)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("got error: %v, want: nil", err)
+		}
+		ids = append(ids, id)
+	}
+
+	received := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-messages:
+			received[msg] = true
+		case <-time.After(5 * time.Second):
+			t.Fatal("message wasn't received")
+		}
+	}
+	for _, want := range []string{`{"value":"a"}`, `{"value":"b"}`} {
+		if !received[want] {
+			t.Errorf("message %s wasn't received, got: %v", want, received)
+		}
+	}
+
+	if err := sseClient.Unsubscribe(ids[0]); err != nil {
+		t.Fatalf("got error: %v, want: nil", err)
+	}
+	handler.mu.Lock()
+	cancelled := handler.cancelled
+	handler.mu.Unlock()
+	if len(cancelled) != 1 || cancelled[0] != ids[0] {
+		t.Errorf("got cancelled operations: %v, want: [%s]", cancelled, ids[0])
+	}
+
+	if err := sseClient.Unsubscribe(ids[0]); err == nil {
+		t.Error("expected error when unsubscribing twice")
+	}
+}
+
+func TestSSEReader(t *testing.T) {
+	stream := ": comment\n\n" +
+		"event: next\r\ndata: {\"a\":\r\ndata:1}\r\n\r\n" +
+		"event:complete\ndata\n\n" +
+		"event: next\ndata: {}"
+
+	reader := newSSEReader(strings.NewReader(stream))
+
+	event, err := reader.next()
+	if err != nil {
+		t.Fatalf("got error: %v, want: nil", err)
+	}
+	if event.name != "next" || string(event.data) != "{\"a\":\n1}" {
+		t.Errorf("got event: %s %q, want: next {\"a\":\\n1}", event.name, event.data)
+	}
+
+	event, err = reader.next()
+	if err != nil {
+		t.Fatalf("got error: %v, want: nil", err)
+	}
+	if event.name != "complete" || len(event.data) != 0 {
+		t.Errorf("got event: %s %q, want: complete", event.name, event.data)
+	}
+
+	// the last event isn't terminated
+	if _, err = reader.next(); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("got error: %v, want: %v", err, io.ErrUnexpectedEOF)
+	}
+}
@@ -5,11 +5,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
-	"strings"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
@@ -51,7 +48,25 @@ const (
 	GQL_INTERNAL OperationMessageType = "internal"
 )
 
+// Message types of the graphql-transport-ws protocol which don't exist in subscriptions-transport-ws
+// https://github.com/enisdenjo/graphql-ws/blob/master/PROTOCOL.md
 const (
+	// Client sends this message to execute GraphQL operation, like GQL_START
+	GQL_SUBSCRIBE OperationMessageType = "subscribe"
+	// Server sends this message to transfer the GraphQL execution result, like GQL_DATA
+	GQL_NEXT OperationMessageType = "next"
+	// Both sides may send this message to check the connection. It must be answered with GQL_PONG as soon as possible
+	GQL_PING OperationMessageType = "ping"
+	// Both sides send this message in response to GQL_PING, or unidirectionally as a keep alive
+	GQL_PONG OperationMessageType = "pong"
+)
+
+const (
+	// StatusUnauthorized is the websocket close code sent by servers when the connection isn't authenticated
+	StatusUnauthorized = 4401
+	// StatusForbidden is the websocket close code sent by servers when the connection isn't authorized
+	StatusForbidden = 4403
+
 	// defaultReadLimit is the default maximum message size in bytes (10MB)
 	defaultReadLimit = 10 * 1024 * 1024
 
@@ -62,6 +77,71 @@ const (
 // ErrSubscriptionStopped a special error which forces the subscription stop
 var ErrSubscriptionStopped = errors.New("subscription stopped")
 
+// ErrUnsubscribe is a special error which a handler returns to unsubscribe its own subscription.
+// The other subscriptions keep running, and the error isn't reported to the OnError event
+var ErrUnsubscribe = errors.New("unsubscribe")
+
+// ErrorPolicy decides what happens when the handler of a subscription returns an error.
+// ErrSubscriptionStopped and ErrUnsubscribe keep their meaning whatever the policy
+type ErrorPolicy int
+
+const (
+	// ErrorPolicyReport sends the error to the OnError event. This is the default policy
+	ErrorPolicyReport ErrorPolicy = iota
+	// ErrorPolicyStop unsubscribes the subscription. The error isn't reported to the OnError event
+	ErrorPolicyStop
+	// ErrorPolicyIgnore ignores the error, the subscription keeps running
+	ErrorPolicyIgnore
+)
+
+// ErrOperationInterrupted is returned by Query and Mutate when the connection is closed
+// before the server completes the operation. The operation isn't sent again on reconnection
+var ErrOperationInterrupted = errors.New("connection closed before the operation completed")
+
+// ErrClientNotRunning is returned by Query and Mutate when the client is neither running nor in lazy mode
+var ErrClientNotRunning = errors.New("subscription client isn't running")
+
+// ErrConnectionAckTimeout is reported when the server doesn't acknowledge
+// the connection_init message within the configured connection ack timeout
+var ErrConnectionAckTimeout = errors.New("connection_ack timeout")
+
+// ConnectionRejectedError is reported when the server rejects the connection_init
+// message with a GQL_CONNECTION_ERROR message, e.g. because authentication failed.
+// Payload holds the raw error payload sent by the server.
+type ConnectionRejectedError struct {
+	Payload json.RawMessage
+}
+
+func (e *ConnectionRejectedError) Error() string {
+	if len(e.Payload) == 0 {
+		return "connection rejected by server"
+	}
+	return fmt.Sprintf("connection rejected by server: %s", string(e.Payload))
+}
+
+// SubscriptionError is reported when the server fails an operation with a GQL_ERROR message,
+// usually due to GraphQL validation errors. ID is the subscription ID returned by Subscribe.
+type SubscriptionError struct {
+	ID      string
+	Payload json.RawMessage
+	Errors  Errors
+}
+
+func (e *SubscriptionError) Error() string {
+	if len(e.Errors) > 0 {
+		return fmt.Sprintf("subscription %s failed: %s", e.ID, e.Errors.Error())
+	}
+	return fmt.Sprintf("subscription %s failed: %s", e.ID, string(e.Payload))
+}
+
+// Unwrap returns the GraphQL errors sent by the server, if any
+func (e *SubscriptionError) Unwrap() error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	return e.Errors
+}
+
 // OperationMessage represents a subscription operation message
 type OperationMessage struct {
 	ID      string               `json:"id,omitempty"`
@@ -88,46 +168,142 @@ type WebsocketConn interface {
 	SetReadLimit(limit int64)
 }
 
+// ConnectionState represents the lifecycle state of the websocket connection of SubscriptionClient
+type ConnectionState int32
+
+const (
+	// ConnectionIdle is the state of a client which isn't running, before Run or after Run returned
+	ConnectionIdle ConnectionState = iota
+	// ConnectionConnecting is the state of a running client until the server acknowledges the first connection
+	ConnectionConnecting
+	// ConnectionConnected is the state of a client whose connection was acknowledged by the server
+	ConnectionConnected
+	// ConnectionReconnecting is the state of a client whose connection was lost or reset, until the server acknowledges the new connection
+	ConnectionReconnecting
+	// ConnectionClosed is the state of a client which was stopped with Close or Shutdown
+	ConnectionClosed
+)
+
+func (cs ConnectionState) String() string {
+	switch cs {
+	case ConnectionIdle:
+		return "idle"
+	case ConnectionConnecting:
+		return "connecting"
+	case ConnectionConnected:
+		return "connected"
+	case ConnectionReconnecting:
+		return "reconnecting"
+	case ConnectionClosed:
+		return "closed"
+	default:
+		return fmt.Sprintf("unknown(%d)", int32(cs))
+	}
+}
+
 type handlerFunc func(data []byte, err error) error
 type subscription struct {
 	query     string
 	variables map[string]any
 	handler   func(data []byte, err error)
-	started   Boolean
+	started   bool
+	// failed is true when the server ended the subscription with an error, it isn't started again
+	failed bool
+	// done is closed when the server completes a one-off operation sent by Query or Mutate
+	done chan struct{}
+	// live is the latest result of a live query
+	live *liveState
+	// onComplete is called when the server completes the subscription
+	onComplete func()
+	// errorPolicy applies to the errors returned by the handler
+	errorPolicy ErrorPolicy
+	// shared holds the subscribers of a deduplicated subscription
+	shared *sharedOperation
+	stats  subscriptionStats
 }
 
 // SubscriptionClient is a GraphQL subscription client.
+//
+// The With* and On* methods configure the client and must be called before Run.
+// Other methods are safe for concurrent use.
 type SubscriptionClient struct {
 	url              string
-	conn             WebsocketConn
 	connectionParams map[string]any
-	websocketOptions WebsocketOptions
-	context          context.Context
-	subscriptions    map[string]*subscription
-	cancel           context.CancelFunc
-	subscribersMu    sync.Mutex
-	timeout          time.Duration
-	isRunning        int64
-	readLimit        int64 // max size of response message. Default 10 MB
-	log              func(args ...any)
-	createConn       func(sc *SubscriptionClient) (WebsocketConn, error)
-	retryTimeout     time.Duration
-	onConnected      func()
-	onDisconnected   func()
-	onError          func(sc *SubscriptionClient, err error) error
-	errorChan        chan error
-	disabledLogTypes []OperationMessageType
+	// connectionParamsFn overrides connectionParams, it's called on every connection_init
+	connectionParamsFn func(ctx context.Context) (map[string]any, error)
+	websocketOptions   WebsocketOptions
+	timeout            time.Duration
+	readLimit          int64 // max size of response message. Default 10 MB
+	log                func(args ...any)
+	createConn         func(sc *SubscriptionClient) (WebsocketConn, error)
+	retryTimeout       time.Duration
+	onConnected        func()
+	onDisconnected     func()
+	onError            func(sc *SubscriptionClient, err error) error
+	onReconnecting     func(attempt int, cause error)
+	onResubscribed     func(ids []string)
+	onMessageReceived  func(message OperationMessage)
+	onMessageSent      func(message OperationMessage)
+	disabledLogTypes   []OperationMessageType
+	// connectionAckTimeout is the max duration to wait for GQL_CONNECTION_ACK. Zero means no limit
+	connectionAckTimeout time.Duration
+	onReauthenticate     func(ctx context.Context, code int) error
+	authCloseCodes       []int
+	// lazy mode connects on the first subscription and disconnects after the idle timeout
+	lazy        bool
+	idleTimeout time.Duration
+	// protocols are offered during the handshake, in order of preference
+	protocols []SubscriptionProtocol
+	// deduplicate shares a single server operation among identical subscriptions
+	deduplicate bool
+	// scalars maps Go types to custom GraphQL scalars, it may be nil
+	scalars *ScalarRegistry
+
+	// mu guards the connection and the subscriptions.
+	// Messages are written to the connection while holding mu, so they are sent in order
+	mu            sync.Mutex
+	conn          WebsocketConn
+	protocol      SubscriptionProtocol
+	context       context.Context
+	cancel        context.CancelFunc
+	subscriptions map[string]*subscription
+	// sharedKeys maps the keys of deduplicated subscriptions to their server operation ID,
+	// sharedIDs maps the IDs returned to the subscribers to the server operation ID
+	sharedKeys map[string]string
+	sharedIDs  map[string]string
+	idleTimer  *time.Timer
+	// running is true while a run loop is active, started by Run or by the lazy mode
+	running bool
+	// runCtx is cancelled when the run loop stops, runCancel stops the run loop
+	runCtx    context.Context
+	runCancel context.CancelFunc
+
+	// stateMu guards the connection state. stateChanged is closed and replaced on every transition
+	stateMu      sync.Mutex
+	state        ConnectionState
+	stateChanged chan struct{}
+
+	errorChan  chan error
+	resetChan  chan struct{}
+	handlersWg sync.WaitGroup
+	readersWg  sync.WaitGroup
 }
 
 func NewSubscriptionClient(url string) *SubscriptionClient {
 	return &SubscriptionClient{
-		url:           url,
-		timeout:       time.Minute,
-		readLimit:     defaultReadLimit,
-		subscriptions: make(map[string]*subscription),
-		createConn:    newWebsocketConn,
-		retryTimeout:  time.Minute,
-		errorChan:     make(chan error),
+		url:            url,
+		timeout:        time.Minute,
+		readLimit:      defaultReadLimit,
+		subscriptions:  make(map[string]*subscription),
+		sharedKeys:     make(map[string]string),
+		sharedIDs:      make(map[string]string),
+		createConn:     newWebsocketConn,
+		retryTimeout:   time.Minute,
+		errorChan:      make(chan error),
+		resetChan:      make(chan struct{}, 1),
+		stateChanged:   make(chan struct{}),
+		authCloseCodes: []int{StatusUnauthorized, StatusForbidden},
+		protocols:      []SubscriptionProtocol{ProtocolSubscriptionsTransportWS},
 	}
 }
 
@@ -138,6 +314,9 @@ func (sc *SubscriptionClient) GetURL() string {
 
 // GetContext returns current context of subscription client
 func (sc *SubscriptionClient) GetContext() context.Context {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
 	return sc.context
 }
 
@@ -146,6 +325,53 @@ func (sc *SubscriptionClient) GetTimeout() time.Duration {
 	return sc.timeout
 }
 
+// State returns the current state of the websocket connection
+func (sc *SubscriptionClient) State() ConnectionState {
+	sc.stateMu.Lock()
+	defer sc.stateMu.Unlock()
+
+	return sc.state
+}
+
+// WaitForState blocks until the websocket connection reaches the state, or ctx is done
+func (sc *SubscriptionClient) WaitForState(
+	ctx context.Context,
+	state ConnectionState,
+) error {
+	for {
+		sc.stateMu.Lock()
+		current, changed := sc.state, sc.stateChanged
+		sc.stateMu.Unlock()
+
+		if current == state {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+func (sc *SubscriptionClient) setState(state ConnectionState) {
+	sc.stateMu.Lock()
+	defer sc.stateMu.Unlock()
+
+	if sc.state == state {
+		return
+	}
+	sc.printLog(
+		fmt.Sprintf("connection state: %s -> %s", sc.state, state),
+		"client",
+		GQL_INTERNAL,
+	)
+	sc.state = state
+	close(sc.stateChanged)
+	sc.stateChanged = make(chan struct{})
+}
+
 // WithWebSocket replaces customized websocket client constructor
 // In default, subscription client uses https://github.com/nhooyr/websocket
 func (sc *SubscriptionClient) WithWebSocket(
@@ -172,6 +398,35 @@ func (sc *SubscriptionClient) WithConnectionParams(
 	return sc
 }
 
+// WithConnectionParamsFn sets a function which provides connection params for every GQL_CONNECTION_INIT event,
+// including the ones sent by automatic reconnections. Use it to send short-lived credentials which must be refreshed.
+// It overrides the params set by WithConnectionParams
+func (sc *SubscriptionClient) WithConnectionParamsFn(
+	fn func(ctx context.Context) (map[string]any, error),
+) *SubscriptionClient {
+	sc.connectionParamsFn = fn
+	return sc
+}
+
+// WithLazyConnect enables the lazy mode. The client connects on the first Subscribe call, without calling Run,
+// and disconnects when no subscription is left for the idle timeout duration.
+// The connection is reopened transparently on the next Subscribe call.
+// Errors of the connection are reported to OnError
+func (sc *SubscriptionClient) WithLazyConnect(
+	idleTimeout time.Duration,
+) *SubscriptionClient {
+	sc.lazy = true
+	sc.idleTimeout = idleTimeout
+	return sc
+}
+
+// WithAuthCloseCodes sets the websocket close codes which trigger the OnReauthenticate event.
+// Default codes are StatusUnauthorized (4401) and StatusForbidden (4403)
+func (sc *SubscriptionClient) WithAuthCloseCodes(codes ...int) *SubscriptionClient {
+	sc.authCloseCodes = codes
+	return sc
+}
+
 // WithTimeout updates write timeout of websocket client
 func (sc *SubscriptionClient) WithTimeout(
 	timeout time.Duration,
@@ -188,6 +443,17 @@ func (sc *SubscriptionClient) WithRetryTimeout(
 	return sc
 }
 
+// WithConnectionAckTimeout sets the max duration to wait for the server to acknowledge the connection_init message.
+// If the GQL_CONNECTION_ACK message doesn't arrive in time, ErrConnectionAckTimeout is reported to OnError,
+// and the connection is closed. The client reconnects unless OnError returns an error.
+// By default, the client waits indefinitely
+func (sc *SubscriptionClient) WithConnectionAckTimeout(
+	timeout time.Duration,
+) *SubscriptionClient {
+	sc.connectionAckTimeout = timeout
+	return sc
+}
+
 // WithLog sets loging function to print out received messages. By default, nothing is printed
 func (sc *SubscriptionClient) WithLog(
 	logger func(args ...any),
@@ -211,6 +477,7 @@ func (sc *SubscriptionClient) WithReadLimit(limit int64) *SubscriptionClient {
 }
 
 // OnError event is triggered when there is any connection error. This is bottom exception handler level
+// Errors sent by the server are reported as *ConnectionRejectedError or *SubscriptionError
 // If this function is empty, or returns nil, the error is ignored
 // If returns error, the websocket connection will be terminated
 func (sc *SubscriptionClient) OnError(
@@ -232,60 +499,218 @@ func (sc *SubscriptionClient) OnDisconnected(fn func()) *SubscriptionClient {
 	return sc
 }
 
-func (sc *SubscriptionClient) setIsRunning(value Boolean) {
-	if value {
-		atomic.StoreInt64(&sc.isRunning, 1)
-	} else {
-		atomic.StoreInt64(&sc.isRunning, 0)
-	}
+// OnReconnecting event is triggered before every attempt to reconnect after the connection was lost or reset.
+// attempt starts at 1 for each reconnection. cause is the error which closed the connection,
+// or the dial error of the previous attempt. It's nil when the connection was reset with Reset
+func (sc *SubscriptionClient) OnReconnecting(
+	fn func(attempt int, cause error),
+) *SubscriptionClient {
+	sc.onReconnecting = fn
+	return sc
 }
 
-func (sc *SubscriptionClient) init() error {
+// OnResubscribed event is triggered when the server acknowledges a new connection after a reconnection,
+// with the IDs of the subscriptions started again
+func (sc *SubscriptionClient) OnResubscribed(fn func(ids []string)) *SubscriptionClient {
+	sc.onResubscribed = fn
+	return sc
+}
 
-	now := time.Now()
-	ctx, cancel := context.WithCancel(context.Background())
-	sc.context = ctx
-	sc.cancel = cancel
+// OnMessageReceived event is triggered for every message read from the connection, before it's handled.
+// The message type is the one of the negotiated protocol.
+// The function is called by the reader goroutine, it must not block nor call the methods of the client
+func (sc *SubscriptionClient) OnMessageReceived(
+	fn func(message OperationMessage),
+) *SubscriptionClient {
+	sc.onMessageReceived = fn
+	return sc
+}
 
-	for {
-		var err error
-		var conn WebsocketConn
-		// allow custom websocket client
-		if sc.conn == nil {
-			conn, err = sc.createConn(sc)
-			if err == nil {
-				sc.conn = conn
-			}
-		}
+// OnMessageSent event is triggered for every message written to the connection.
+// The message type is the one of the negotiated protocol.
+// The function is called while writing messages, it must not block nor call the methods of the client
+func (sc *SubscriptionClient) OnMessageSent(
+	fn func(message OperationMessage),
+) *SubscriptionClient {
+	sc.onMessageSent = fn
+	return sc
+}
 
-		if err == nil {
-			sc.conn.SetReadLimit(sc.readLimit)
-			// send connection init event to the server
-			err = sc.sendConnectionInit()
+// OnReauthenticate event is triggered when the server closes the connection with an auth-related close code
+// (see WithAuthCloseCodes), before reconnecting. Use it to refresh the credentials returned by the connection params function.
+// If the function returns nil, the client reconnects. Otherwise the error is reported to OnError and the client stops reconnecting
+func (sc *SubscriptionClient) OnReauthenticate(
+	fn func(ctx context.Context, code int) error,
+) *SubscriptionClient {
+	sc.onReauthenticate = fn
+	return sc
+}
+
+func (sc *SubscriptionClient) isAuthCloseCode(code int) bool {
+	for _, c := range sc.authCloseCodes {
+		if c == code {
+			return true
 		}
+	}
+	return false
+}
 
+// connect opens the websocket connection and sends the connection_init message.
+// It retries every second until the retry timeout, or ctx is done.
+// When reconnecting, the OnReconnecting event is triggered before every attempt, starting with cause
+func (sc *SubscriptionClient) connect(
+	ctx context.Context,
+	reconnecting bool,
+	cause error,
+) (WebsocketConn, error) {
+	now := time.Now()
+	for attempt := 1; ; attempt++ {
+		if reconnecting && sc.onReconnecting != nil {
+			sc.onReconnecting(attempt, cause)
+		}
+		conn, err := sc.dial(ctx)
 		if err == nil {
-			return nil
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		// the server doesn't implement the offered protocols, retrying won't help
+		var negotiationErr *ProtocolNegotiationError
+		if errors.As(err, &negotiationErr) {
+			return nil, err
 		}
 
 		if now.Add(sc.retryTimeout).Before(time.Now()) {
-			if sc.onDisconnected != nil {
-				sc.onDisconnected()
-			}
-			return err
+			return nil, err
 		}
+		cause = err
 		sc.printLog(
 			fmt.Sprintf("%s. retry in second...", err.Error()),
 			"client",
 			GQL_INTERNAL,
 		)
-		time.Sleep(retryInterval)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(retryInterval):
+		}
 	}
 }
 
-func (sc *SubscriptionClient) writeJSON(v any) error {
-	if sc.conn != nil {
-		return sc.conn.WriteJSON(v)
+// dial creates the websocket connection with the custom constructor and sends the connection_init message
+func (sc *SubscriptionClient) dial(ctx context.Context) (WebsocketConn, error) {
+	connCtx, cancel := context.WithCancel(ctx)
+	sc.mu.Lock()
+	sc.context = connCtx
+	sc.cancel = cancel
+	sc.mu.Unlock()
+
+	conn, err := sc.createConn(sc)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	protocol, err := sc.negotiateProtocol(conn)
+	if err != nil {
+		_ = conn.Close()
+		cancel()
+		return nil, err
+	}
+
+	conn.SetReadLimit(sc.readLimit)
+	// send connection init event to the server
+	if err := sc.sendConnectionInit(connCtx, conn); err != nil {
+		_ = conn.Close()
+		cancel()
+		return nil, err
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	// the client was closed while connecting
+	if ctx.Err() != nil {
+		_ = conn.Close()
+		cancel()
+		return nil, ctx.Err()
+	}
+	sc.conn = conn
+	sc.protocol = protocol
+
+	return conn, nil
+}
+
+// watchConnectionAck returns a channel receiving ErrConnectionAckTimeout if the server doesn't acknowledge
+// the connection before the connection ack timeout elapses. The timer is stopped when ctx is done,
+// so the timeout of a closed connection isn't received by the next one
+func (sc *SubscriptionClient) watchConnectionAck(ctx context.Context) <-chan error {
+	timeoutChan := make(chan error, 1)
+	if sc.connectionAckTimeout <= 0 {
+		return timeoutChan
+	}
+
+	go func() {
+		timer := time.NewTimer(sc.connectionAckTimeout)
+		defer timer.Stop()
+
+		select {
+		case <-ctx.Done():
+		case <-timer.C:
+			if sc.State() != ConnectionConnected {
+				timeoutChan <- fmt.Errorf(
+					"%w after %s",
+					ErrConnectionAckTimeout,
+					sc.connectionAckTimeout,
+				)
+			}
+		}
+	}()
+	return timeoutChan
+}
+
+// reportError forwards the error to the run loop, which triggers the OnError event.
+// The error is dropped if the client isn't running
+func (sc *SubscriptionClient) reportError(err error) {
+	sc.mu.Lock()
+	ctx := sc.runCtx
+	sc.mu.Unlock()
+
+	if ctx == nil {
+		return
+	}
+
+	select {
+	case sc.errorChan <- err:
+	case <-ctx.Done():
+	}
+}
+
+// notifySubscriptions sends the error to the handlers of all registered subscriptions
+func (sc *SubscriptionClient) notifySubscriptions(err error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	for _, sub := range sc.subscriptions {
+		sc.dispatch(sub, nil, err)
+	}
+}
+
+// writeMessage writes the message to the current connection, in the negotiated protocol. The caller must hold mu
+func (sc *SubscriptionClient) writeMessage(msg OperationMessage) error {
+	if sc.conn == nil {
+		return nil
+	}
+	msgType, ok := sc.protocol.encodeType(msg.Type)
+	if !ok {
+		return nil
+	}
+	msg.Type = msgType
+	if err := sc.conn.WriteJSON(msg); err != nil {
+		return err
+	}
+	if sc.onMessageSent != nil {
+		sc.onMessageSent(msg)
 	}
 	return nil
 }
@@ -307,11 +732,22 @@ func (sc *SubscriptionClient) printLog(
 	sc.log(message, source)
 }
 
-func (sc *SubscriptionClient) sendConnectionInit() (err error) {
+func (sc *SubscriptionClient) sendConnectionInit(
+	ctx context.Context,
+	conn WebsocketConn,
+) (err error) {
+	params := sc.connectionParams
+	if sc.connectionParamsFn != nil {
+		params, err = sc.connectionParamsFn(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get connection params: %w", err)
+		}
+	}
+
 	var bParams []byte = nil
-	if sc.connectionParams != nil {
+	if params != nil {
 
-		bParams, err = json.Marshal(sc.connectionParams)
+		bParams, err = json.Marshal(params)
 		if err != nil {
 			return
 		}
@@ -324,7 +760,13 @@ func (sc *SubscriptionClient) sendConnectionInit() (err error) {
 	}
 
 	sc.printLog(msg, "client", GQL_CONNECTION_INIT)
-	return sc.writeJSON(msg)
+	if err := conn.WriteJSON(msg); err != nil {
+		return err
+	}
+	if sc.onMessageSent != nil {
+		sc.onMessageSent(msg)
+	}
+	return nil
 }
 
 // Subscribe sends start message to server and open a channel to receive data.
@@ -380,141 +822,608 @@ func (sc *SubscriptionClient) do(
 	handler func(message []byte, err error) error,
 	options ...Option,
 ) (string, error) {
-	query, err := ConstructSubscription(v, variables, options...)
+	query, omitted, err := constructOperation("subscription", v, variables, true, sc.scalars, options...)
 	if err != nil {
 		return "", err
 	}
 
-	return sc.doRaw(query, variables, handler)
+	return sc.doRaw(query, omitVariableMap(variables, omitted), handler, options...)
 }
 
 func (sc *SubscriptionClient) doRaw(
 	query string,
 	variables map[string]any,
 	handler func(message []byte, err error) error,
+	options ...Option,
 ) (string, error) {
+	variables, err := sc.scalars.encodeVariableMap(variables)
+	if err != nil {
+		return "", err
+	}
 	id := uuid.New().String()
 
 	sub := subscription{
 		query:     query,
 		variables: variables,
-		handler:   sc.wrapHandler(handler),
+		stats:     subscriptionStats{createdAt: time.Now()},
 	}
+	applySubscriptionOptions(&sub, options)
+	sub.handler = sc.wrapHandler(id, sub.errorPolicy, handler)
 
-	// if the websocket client is running, start subscription immediately
-	if atomic.LoadInt64(&sc.isRunning) > 0 {
-		if err := sc.startSubscription(id, &sub); err != nil {
-			return "", err
+	if sc.deduplicate {
+		if key, ok := subscriptionKey(query, variables); ok {
+			if err := sc.addSharedSubscriber(key, id, &sub); err != nil {
+				return "", err
+			}
+			return id, nil
 		}
 	}
 
-	sc.subscribersMu.Lock()
-	sc.subscriptions[id] = &sub
-	sc.subscribersMu.Unlock()
+	if err := sc.addSubscription(id, &sub); err != nil {
+		return "", err
+	}
 
 	return id, nil
 }
 
-// Subscribe sends start message to server and open a channel to receive data
-func (sc *SubscriptionClient) startSubscription(
-	id string,
-	sub *subscription,
-) error {
-	if sub == nil || sub.started {
-		return nil
-	}
-
-	in := struct {
-		Query     string         `json:"query"`
-		Variables map[string]any `json:"variables,omitempty"`
-	}{
-		Query:     sub.query,
-		Variables: sub.variables,
-	}
+// addSubscription registers the subscription, and starts it if the websocket client is connected
+func (sc *SubscriptionClient) addSubscription(id string, sub *subscription) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
 
-	payload, err := json.Marshal(in)
-	if err != nil {
-		return err
-	}
+	return sc.registerSubscription(id, sub)
+}
 
-	// send stop message to the server
-	msg := OperationMessage{
-		ID:      id,
-		Type:    GQL_START,
-		Payload: payload,
+// registerSubscription registers the subscription like addSubscription. The caller must hold mu
+func (sc *SubscriptionClient) registerSubscription(id string, sub *subscription) error {
+	// if the websocket client is connected, start subscription immediately.
+	// The connection of a stopping run loop, e.g. closed by the idle timeout, is skipped:
+	// the subscription starts on the next connection
+	if sc.State() == ConnectionConnected && (sc.runCtx == nil || sc.runCtx.Err() == nil) {
+		if err := sc.startSubscription(id, sub); err != nil {
+			return err
+		}
 	}
 
-	sc.printLog(msg, "client", GQL_START)
-	if err := sc.writeJSON(msg); err != nil {
-		return err
-	}
+	sc.subscriptions[id] = sub
+	sc.stopIdleTimer()
+	sc.runLazily()
 
-	sub.started = true
 	return nil
 }
 
-func (sc *SubscriptionClient) wrapHandler(
-	fn handlerFunc,
-) func(data []byte, err error) {
-	return func(data []byte, err error) {
-		if errValue := fn(data, err); errValue != nil {
-			sc.errorChan <- errValue
-		}
+// Query executes a single GraphQL query over the websocket connection,
+// with a query derived from q, populating the response into it.
+// q should be a pointer to struct that corresponds to the GraphQL schema.
+//
+// Query waits until the server completes the operation, or ctx is done.
+// The client must be running, or in lazy mode, otherwise ErrClientNotRunning is returned
+func (sc *SubscriptionClient) Query(
+	ctx context.Context,
+	q any,
+	variables map[string]any,
+	options ...Option,
+) error {
+	query, omitted, err := constructOperation("query", q, variables, false, sc.scalars, options...)
+	if err != nil {
+		return newSimpleErrors(ErrGraphQLEncode, err)
 	}
-}
 
-// handleDataOrErrorMessage processes GQL_DATA and GQL_ERROR messages
-func (sc *SubscriptionClient) handleDataOrErrorMessage(message OperationMessage) {
-	sc.printLog(message, "server", message.Type)
+	return sc.execOperation(ctx, query, q, omitVariableMap(variables, omitted))
+}
 
-	id, err := uuid.Parse(message.ID)
+// Mutate executes a single GraphQL mutation over the websocket connection,
+// with a mutation derived from m, populating the response into it.
+// m should be a pointer to struct that corresponds to the GraphQL schema.
+//
+// Mutate waits until the server completes the operation, or ctx is done.
+// The client must be running, or in lazy mode, otherwise ErrClientNotRunning is returned
+func (sc *SubscriptionClient) Mutate(
+	ctx context.Context,
+	m any,
+	variables map[string]any,
+	options ...Option,
+) error {
+	query, omitted, err := constructOperation("mutation", m, variables, true, sc.scalars, options...)
 	if err != nil {
-		return
+		return newSimpleErrors(ErrGraphQLEncode, err)
 	}
 
-	sc.subscribersMu.Lock()
-	sub, ok := sc.subscriptions[id.String()]
-	sc.subscribersMu.Unlock()
-
-	if !ok {
-		return
-	}
+	return sc.execOperation(ctx, query, m, omitVariableMap(variables, omitted))
+}
 
-	var out struct {
-		Data   *json.RawMessage
-		Errors Errors
+// execOperation sends the one-off operation, waits for its result and completion, then decodes the data into v
+func (sc *SubscriptionClient) execOperation(
+	ctx context.Context,
+	query string,
+	v any,
+	variables map[string]any,
+) error {
+	type result struct {
+		data []byte
+		err  error
 	}
+	results := make(chan result, 2)
 
-	err = json.Unmarshal(message.Payload, &out)
+	variables, err := sc.scalars.encodeVariableMap(variables)
 	if err != nil {
-		go sub.handler(nil, err)
-		return
+		return newSimpleErrors(ErrGraphQLEncode, err)
 	}
-	if len(out.Errors) > 0 {
-		go sub.handler(nil, out.Errors)
-		return
+
+	// the operation would wait for a connection until ctx is done
+	sc.mu.Lock()
+	running := sc.running || sc.lazy
+	sc.mu.Unlock()
+	if !running {
+		return ErrClientNotRunning
 	}
 
-	var outData []byte
-	if out.Data != nil && len(*out.Data) > 0 {
-		outData = *out.Data
+	id := uuid.New().String()
+	sub := &subscription{
+		query:     query,
+		variables: variables,
+		// the handler of operations is called synchronously by the reader, so it must not block
+		handler: func(data []byte, err error) {
+			select {
+			case results <- result{data, err}:
+			default:
+			}
+		},
+		done: make(chan struct{}),
 	}
 
-	go sub.handler(outData, nil)
-}
+	if err := sc.addSubscription(id, sub); err != nil {
+		return err
+	}
 
-// handleConnectionAckMessage processes GQL_CONNECTION_ACK messages
-func (sc *SubscriptionClient) handleConnectionAckMessage(message OperationMessage) {
-	sc.printLog(message, "server", GQL_CONNECTION_ACK)
-	if sc.onConnected != nil {
-		sc.onConnected()
+	var data []byte
+	var resultErr error
+	for {
+		select {
+		case res := <-results:
+			data, resultErr = res.data, res.err
+			// the GraphQL errors of the result are returned after the completion, other errors end the operation.
+			// The operation isn't stopped if the server ended it
+			if _, ok := res.err.(Errors); res.err != nil && !ok {
+				sc.removeOperation(id, true)
+				return res.err
+			}
+		case <-sub.done:
+			// the result is handled before the completion
+			select {
+			case res := <-results:
+				data, resultErr = res.data, res.err
+			default:
+			}
+			return sc.decodeOperationResult(v, data, resultErr)
+		case <-ctx.Done():
+			sc.removeOperation(id, true)
+			return ctx.Err()
+		}
 	}
 }
 
-// handleCompleteMessage processes GQL_COMPLETE messages
-func (sc *SubscriptionClient) handleCompleteMessage(message OperationMessage) {
+// decodeOperationResult decodes the data of a one-off operation into v, and returns err.
+// Like Client.Query, the partial data sent with errors is decoded, and a decoding error is added to the GraphQL errors
+func (sc *SubscriptionClient) decodeOperationResult(v any, data []byte, err error) error {
+	if len(data) == 0 {
+		return err
+	}
+	if decodeErr := sc.scalars.UnmarshalGraphQL(data, v); decodeErr != nil {
+		var errs Errors
+		if err != nil && !errors.As(err, &errs) {
+			return err
+		}
+		return append(errs, newError(ErrGraphQLDecode, decodeErr))
+	}
+	return err
+}
+
+// removeOperation deletes the one-off operation, and sends the stop message if stop is true.
+// Unlike Unsubscribe, the client isn't closed when it was the last subscription
+func (sc *SubscriptionClient) removeOperation(id string, stop bool) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	sub, ok := sc.subscriptions[id]
+	if !ok {
+		return
+	}
+	delete(sc.subscriptions, id)
+	if stop && sub.started {
+		_ = sc.stopSubscription(id)
+	}
+	if sc.lazy && sc.running && len(sc.subscriptions) == 0 {
+		sc.startIdleTimer()
+	}
+}
+
+// runLazily runs the client in background if the lazy mode is enabled and the client isn't running yet.
+// The caller must hold mu
+func (sc *SubscriptionClient) runLazily() {
+	if !sc.lazy || sc.running {
+		return
+	}
+	sc.running = true
+
+	go func() {
+		for {
+			err := sc.run(context.Background())
+
+			sc.mu.Lock()
+			// subscriptions may be added while the idle connection was closing
+			again := err == nil && len(sc.subscriptions) > 0 &&
+				sc.State() != ConnectionClosed
+			sc.running = again
+			sc.mu.Unlock()
+
+			if !again {
+				return
+			}
+		}
+	}()
+}
+
+// startIdleTimer schedules the disconnection of the idle client. The caller must hold mu
+func (sc *SubscriptionClient) startIdleTimer() {
+	sc.stopIdleTimer()
+	sc.idleTimer = time.AfterFunc(sc.idleTimeout, sc.closeIdleConnection)
+}
+
+// stopIdleTimer cancels the scheduled disconnection. The caller must hold mu
+func (sc *SubscriptionClient) stopIdleTimer() {
+	if sc.idleTimer != nil {
+		sc.idleTimer.Stop()
+		sc.idleTimer = nil
+	}
+}
+
+// closeIdleConnection stops the run loop if there is still no subscription.
+// Unlike Close, subscriptions added in the meantime are kept and started on the next connection
+func (sc *SubscriptionClient) closeIdleConnection() {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	sc.idleTimer = nil
+	if len(sc.subscriptions) > 0 || !sc.running || sc.runCancel == nil {
+		return
+	}
+
+	sc.printLog("idle timeout. closing the connection...", "client", GQL_INTERNAL)
+	sc.runCancel()
+}
+
+// startSubscription sends start message to server. The caller must hold mu
+func (sc *SubscriptionClient) startSubscription(
+	id string,
+	sub *subscription,
+) error {
+	if sub == nil || sub.started || sub.failed {
+		return nil
+	}
+
+	in := struct {
+		Query     string         `json:"query"`
+		Variables map[string]any `json:"variables,omitempty"`
+	}{
+		Query:     sub.query,
+		Variables: sub.variables,
+	}
+
+	payload, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	// send stop message to the server
+	msg := OperationMessage{
+		ID:      id,
+		Type:    GQL_START,
+		Payload: payload,
+	}
+
+	sc.printLog(msg, "client", GQL_START)
+	if err := sc.writeMessage(msg); err != nil {
+		return err
+	}
+
+	sub.started = true
+	sub.stats.startedAt = time.Now()
+	return nil
+}
+
+// applySubscriptionOptions applies the per-subscription options, query options are ignored
+func applySubscriptionOptions(sub *subscription, options []Option) {
+	for _, option := range options {
+		if so, ok := option.(subscriptionOption); ok {
+			so.apply(sub)
+		}
+	}
+}
+
+// wrapHandler handles the error returned by the handler of the subscription id, according to the error policy
+func (sc *SubscriptionClient) wrapHandler(
+	id string,
+	policy ErrorPolicy,
+	fn handlerFunc,
+) func(data []byte, err error) {
+	return func(data []byte, err error) {
+		errValue := fn(data, err)
+		switch {
+		case errValue == nil:
+		case errors.Is(errValue, ErrUnsubscribe):
+			_ = sc.Unsubscribe(id)
+		// ErrSubscriptionStopped stops the client, whatever the policy
+		case errValue == ErrSubscriptionStopped:
+			sc.reportError(errValue)
+		case policy == ErrorPolicyStop:
+			_ = sc.Unsubscribe(id)
+		// the errors reported by the client itself aren't reported twice
+		case policy == ErrorPolicyReport && !isReportedError(errValue, err):
+			sc.reportError(errValue)
+		}
+	}
+}
+
+// isReportedError reports whether the error returned by a handler is the error it received,
+// when the client reports the received error to the OnError event
+func isReportedError(returned error, received error) bool {
+	var subErr *SubscriptionError
+	var rejectedErr *ConnectionRejectedError
+	if !errors.As(received, &subErr) && !errors.As(received, &rejectedErr) {
+		return false
+	}
+	return errors.Is(returned, received)
+}
+
+// dispatch calls the subscription handler in a new goroutine, which is awaited by Shutdown
+func (sc *SubscriptionClient) dispatch(sub *subscription, data []byte, err error) {
+	// one-off operations get their result before the completion
+	if sub.done != nil {
+		sub.handler(data, err)
+		return
+	}
+
+	sc.handlersWg.Add(1)
+	go func() {
+		defer sc.handlersWg.Done()
+		sub.handler(data, err)
+	}()
+}
+
+// getSubscription returns the subscription of the operation message ID
+func (sc *SubscriptionClient) getSubscription(messageID string) (string, *subscription, bool) {
+	id, err := uuid.Parse(messageID)
+	if err != nil {
+		return "", nil, false
+	}
+
+	sc.mu.Lock()
+	sub, ok := sc.subscriptions[id.String()]
+	sc.mu.Unlock()
+
+	return id.String(), sub, ok
+}
+
+// readMessages reads and handles the messages of the connection until it fails.
+// The read error is sent to errChan
+func (sc *SubscriptionClient) readMessages(
+	conn WebsocketConn,
+	protocol SubscriptionProtocol,
+	errChan chan<- error,
+) {
+	defer sc.readersWg.Done()
+
+	for {
+		var message OperationMessage
+		if err := conn.ReadJSON(&message); err != nil {
+			errChan <- err
+			return
+		}
+		if sc.onMessageReceived != nil {
+			sc.onMessageReceived(message)
+		}
+		message.Type = protocol.decodeType(message.Type)
+
+		switch message.Type {
+		case GQL_DATA:
+			sc.handleDataMessage(message)
+		case GQL_ERROR:
+			sc.handleErrorMessage(message)
+		case GQL_CONNECTION_ACK:
+			sc.handleConnectionAckMessage(conn, message)
+		case GQL_COMPLETE:
+			sc.handleCompleteMessage(message)
+		case GQL_CONNECTION_KEEP_ALIVE:
+			sc.handleConnectionKeepAliveMessage(message)
+		case GQL_CONNECTION_ERROR:
+			sc.handleConnectionErrorMessage(message)
+		case GQL_PING:
+			sc.handlePingMessage(conn, message)
+		case GQL_PONG:
+			sc.printLog(message, "server", GQL_PONG)
+		default:
+			sc.handleUnknownMessage(message)
+		}
+	}
+}
+
+// handleDataMessage processes GQL_DATA messages
+func (sc *SubscriptionClient) handleDataMessage(message OperationMessage) {
+	sc.printLog(message, "server", message.Type)
+
+	_, sub, ok := sc.getSubscription(message.ID)
+	if !ok {
+		return
+	}
+	if sub.live != nil {
+		sc.handleLiveDataMessage(sub, message.Payload)
+		return
+	}
+
+	var out struct {
+		Data   *json.RawMessage
+		Errors Errors
+	}
+
+	err := json.Unmarshal(message.Payload, &out)
+	sc.recordMessage(sub, err != nil || len(out.Errors) > 0)
+	if err != nil {
+		sc.dispatch(sub, nil, err)
+		return
+	}
+	var outData []byte
+	if out.Data != nil && len(*out.Data) > 0 {
+		outData = *out.Data
+	}
+
+	if len(out.Errors) > 0 {
+		// the partial data is decoded with the errors of one-off operations only
+		if sub.done == nil {
+			outData = nil
+		}
+		sc.dispatch(sub, outData, out.Errors)
+		return
+	}
+
+	sc.dispatch(sub, outData, nil)
+}
+
+// handleErrorMessage processes GQL_ERROR messages.
+// The error is sent to the subscription handler and to the OnError event
+func (sc *SubscriptionClient) handleErrorMessage(message OperationMessage) {
+	sc.printLog(message, "server", GQL_ERROR)
+
+	id, sub, ok := sc.getSubscription(message.ID)
+	if !ok {
+		return
+	}
+
+	subErr := &SubscriptionError{
+		ID:      id,
+		Payload: message.Payload,
+		Errors:  parseOperationErrors(message.Payload),
+	}
+	sc.recordMessage(sub, true)
+
+	// the server ended the operation, it's neither stopped nor started again
+	sc.mu.Lock()
+	sub.started = false
+	sub.failed = true
+	// identical subscriptions don't join the failed operation
+	if sub.shared != nil {
+		sc.releaseSharedKey(id, sub.shared)
+	}
+	sc.mu.Unlock()
+
+	sc.dispatch(sub, nil, subErr)
+	// errors of one-off operations are returned to the caller only
+	if sub.done == nil {
+		sc.reportError(subErr)
+	}
+}
+
+// parseOperationErrors decodes the payload of a GQL_ERROR message.
+// Servers send either a single error object, an array of errors or an object with an errors field
+func parseOperationErrors(payload json.RawMessage) Errors {
+	if len(payload) == 0 {
+		return nil
+	}
+
+	var errs Errors
+	if err := json.Unmarshal(payload, &errs); err == nil && len(errs) > 0 {
+		return errs
+	}
+
+	var out struct {
+		Errors Errors
+	}
+	if err := json.Unmarshal(payload, &out); err == nil && len(out.Errors) > 0 {
+		return out.Errors
+	}
+
+	var single Error
+	if err := json.Unmarshal(payload, &single); err == nil && single.Message != "" {
+		return Errors{single}
+	}
+
+	return nil
+}
+
+// handleConnectionAckMessage processes GQL_CONNECTION_ACK messages.
+// The connection is ready, pending subscriptions are started
+func (sc *SubscriptionClient) handleConnectionAckMessage(
+	conn WebsocketConn,
+	message OperationMessage,
+) {
+	sc.printLog(message, "server", GQL_CONNECTION_ACK)
+
+	sc.mu.Lock()
+	// the connection was closed in the meantime
+	if sc.conn != conn {
+		sc.mu.Unlock()
+		return
+	}
+	reconnected := sc.State() == ConnectionReconnecting
+	var resubscribed []string
+	for id, sub := range sc.subscriptions {
+		if sub.started || sub.failed {
+			continue
+		}
+		if err := sc.startSubscription(id, sub); err != nil {
+			sc.dispatch(sub, nil, err)
+			continue
+		}
+		switch {
+		case sub.shared != nil:
+			for sharedID := range sub.shared.subscribers {
+				resubscribed = append(resubscribed, sharedID)
+			}
+		case sub.done == nil:
+			resubscribed = append(resubscribed, id)
+		}
+	}
+	sc.setState(ConnectionConnected)
+	sc.mu.Unlock()
+
+	if sc.onConnected != nil {
+		sc.onConnected()
+	}
+	if reconnected && sc.onResubscribed != nil {
+		sc.onResubscribed(resubscribed)
+	}
+}
+
+// handleCompleteMessage processes GQL_COMPLETE messages
+func (sc *SubscriptionClient) handleCompleteMessage(message OperationMessage) {
 	sc.printLog(message, "server", GQL_COMPLETE)
-	_ = sc.Unsubscribe(message.ID)
+
+	id, sub, ok := sc.getSubscription(message.ID)
+	if !ok {
+		return
+	}
+	if sub.done != nil {
+		sc.removeOperation(id, false)
+		close(sub.done)
+		return
+	}
+
+	subscribers := []*subscription{sub}
+	if sub.shared != nil {
+		subscribers = sc.sharedSubscribers(sub.shared)
+	}
+	_ = sc.Unsubscribe(id)
+
+	for _, s := range subscribers {
+		if s.onComplete == nil {
+			continue
+		}
+		sc.handlersWg.Add(1)
+		go func() {
+			defer sc.handlersWg.Done()
+			s.onComplete()
+		}()
+	}
 }
 
 // handleConnectionKeepAliveMessage processes GQL_CONNECTION_KEEP_ALIVE messages
@@ -524,9 +1433,37 @@ func (sc *SubscriptionClient) handleConnectionKeepAliveMessage(
 	sc.printLog(message, "server", GQL_CONNECTION_KEEP_ALIVE)
 }
 
-// handleConnectionErrorMessage processes GQL_CONNECTION_ERROR messages
+// handleConnectionErrorMessage processes GQL_CONNECTION_ERROR messages.
+// The rejection is sent to all subscription handlers and to the OnError event
 func (sc *SubscriptionClient) handleConnectionErrorMessage(message OperationMessage) {
 	sc.printLog(message, "server", GQL_CONNECTION_ERROR)
+
+	rejectedErr := &ConnectionRejectedError{
+		Payload: message.Payload,
+	}
+
+	sc.notifySubscriptions(rejectedErr)
+	sc.reportError(rejectedErr)
+}
+
+// handlePingMessage answers GQL_PING messages of the graphql-transport-ws protocol
+func (sc *SubscriptionClient) handlePingMessage(conn WebsocketConn, message OperationMessage) {
+	sc.printLog(message, "server", GQL_PING)
+
+	msg := OperationMessage{
+		Type: GQL_PONG,
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	// the connection was closed in the meantime
+	if sc.conn != conn {
+		return
+	}
+	sc.printLog(msg, "client", GQL_PONG)
+	if err := sc.writeMessage(msg); err != nil {
+		sc.printLog(fmt.Sprintf("failed to send pong: %s", err), "client", GQL_INTERNAL)
+	}
 }
 
 // handleUnknownMessage processes unknown message types
@@ -536,135 +1473,289 @@ func (sc *SubscriptionClient) handleUnknownMessage(message OperationMessage) {
 
 // Run start websocket client and subscriptions. If this function is run with goroutine, it can be stopped after closed
 func (sc *SubscriptionClient) Run() error {
-	if err := sc.init(); err != nil {
-		return fmt.Errorf("retry timeout, exiting")
+	return sc.RunWithContext(context.Background())
+}
+
+// RunWithContext start websocket client and subscriptions, like Run.
+// When ctx is cancelled, the websocket connection is closed and the function returns nil.
+// Subscriptions are kept, so they are restarted if the client runs again
+func (sc *SubscriptionClient) RunWithContext(ctx context.Context) error {
+	sc.mu.Lock()
+	if sc.running {
+		sc.mu.Unlock()
+		return errors.New("subscription client is already running")
 	}
+	sc.running = true
+	sc.mu.Unlock()
 
-	// lazily start subscriptions
-	sc.subscribersMu.Lock()
-	for k, v := range sc.subscriptions {
-		if err := sc.startSubscription(k, v); err != nil {
-			_ = sc.Unsubscribe(k)
-			return err
-		}
+	defer func() {
+		sc.mu.Lock()
+		sc.running = false
+		sc.mu.Unlock()
+	}()
+
+	return sc.run(ctx)
+}
+
+// run connects to the server and serves the connection, reconnecting until the client is stopped
+func (sc *SubscriptionClient) run(ctx context.Context) error {
+	runCtx, runCancel := context.WithCancel(ctx)
+	defer runCancel()
+
+	sc.mu.Lock()
+	sc.runCtx = runCtx
+	sc.runCancel = runCancel
+	sc.mu.Unlock()
+
+	// discard the reset requested by a previous run
+	select {
+	case <-sc.resetChan:
+	default:
 	}
-	sc.subscribersMu.Unlock()
 
-	sc.setIsRunning(true)
-	go func() {
-		for atomic.LoadInt64(&sc.isRunning) > 0 {
-			select {
-			case <-sc.context.Done():
-				return
-			default:
-				if sc.conn == nil {
-					return
-				}
+	defer func() {
+		sc.mu.Lock()
+		if sc.State() != ConnectionClosed {
+			sc.setState(ConnectionIdle)
+		}
+		sc.mu.Unlock()
+	}()
 
-				var message OperationMessage
-				if err := sc.conn.ReadJSON(&message); err != nil {
-					// manual EOF check
-					if err == io.EOF || strings.Contains(err.Error(), "EOF") {
-						if err = sc.Reset(); err != nil {
-							sc.errorChan <- err
-							return
-						}
-					}
-					closeStatus := websocket.CloseStatus( //nolint:staticcheck // Library still functional
-						err,
-					)
-					if closeStatus == websocket.StatusNormalClosure {
-						// close event from websocket client, exiting...
-						return
-					}
-					if closeStatus != -1 {
-						sc.printLog(
-							fmt.Sprintf("%s. Retry connecting...", err),
-							"client",
-							GQL_INTERNAL,
-						)
-						if err = sc.Reset(); err != nil {
-							sc.errorChan <- err
-							return
-						}
-					}
-
-					if sc.onError != nil {
-						if err = sc.onError(sc, err); err != nil {
-							return
-						}
-					}
-					continue
-				}
+	state := ConnectionConnecting
+	var cause error
+	for {
+		if !sc.transition(runCtx, state) {
+			return nil
+		}
 
-				switch message.Type {
-				case GQL_ERROR, GQL_DATA:
-					sc.handleDataOrErrorMessage(message)
-				case GQL_CONNECTION_ACK:
-					sc.handleConnectionAckMessage(message)
-				case GQL_COMPLETE:
-					sc.handleCompleteMessage(message)
-				case GQL_CONNECTION_KEEP_ALIVE:
-					sc.handleConnectionKeepAliveMessage(message)
-				case GQL_CONNECTION_ERROR:
-					sc.handleConnectionErrorMessage(message)
-				default:
-					sc.handleUnknownMessage(message)
-				}
+		conn, err := sc.connect(runCtx, state == ConnectionReconnecting, cause)
+		if err != nil {
+			if runCtx.Err() != nil {
+				return nil
+			}
+			if sc.onDisconnected != nil {
+				sc.onDisconnected()
+			}
+			// negotiation errors aren't retried
+			var negotiationErr *ProtocolNegotiationError
+			if !errors.As(err, &negotiationErr) {
+				err = fmt.Errorf("retry timeout, exiting: %w", err)
 			}
+			// nobody waits for the lazy run loop, report the error
+			if sc.lazy && sc.onError != nil {
+				_ = sc.onError(sc, err)
+			}
+			return err
 		}
-	}()
 
-	for atomic.LoadInt64(&sc.isRunning) > 0 {
+		reconnect, err := sc.serve(runCtx, conn)
+		if !reconnect {
+			return err
+		}
+		state = ConnectionReconnecting
+		cause = err
+	}
+}
+
+// transition updates the state unless the run loop was stopped, e.g. the client was closed
+func (sc *SubscriptionClient) transition(
+	runCtx context.Context,
+	state ConnectionState,
+) bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if runCtx.Err() != nil {
+		return false
+	}
+	sc.setState(state)
+	return true
+}
+
+// serve reads the messages of the connection and handles the errors until the connection is closed.
+// It returns true if the client must reconnect, with the error which closed the connection
+func (sc *SubscriptionClient) serve(
+	ctx context.Context,
+	conn WebsocketConn,
+) (bool, error) {
+	readErrChan := make(chan error, 1)
+	sc.readersWg.Add(1)
+	go sc.readMessages(conn, sc.Protocol(), readErrChan)
+	ackCtx, cancelAck := context.WithCancel(ctx)
+	defer cancelAck()
+	ackTimeoutChan := sc.watchConnectionAck(ackCtx)
+
+	for {
 		select {
-		case <-sc.context.Done():
-			return nil
+		case <-ctx.Done():
+			sc.closeConnection(conn, true)
+			return false, nil
+		case <-sc.resetChan:
+			sc.printLog("reset the connection...", "client", GQL_INTERNAL)
+			sc.closeConnection(conn, true)
+			return true, nil
 		case e := <-sc.errorChan:
 			// stop the subscription if the error has stop message
 			if e == ErrSubscriptionStopped {
-				return nil
+				sc.closeConnection(conn, true)
+				return false, nil
 			}
 
 			if sc.onError != nil {
 				if err := sc.onError(sc, e); err != nil {
-					return err
+					sc.closeConnection(conn, true)
+					return false, err
 				}
 			}
+		case err := <-ackTimeoutChan:
+			// the connection is closed and the client reconnects, unless OnError returns an error
+			sc.closeConnection(conn, false)
+			if sc.onError != nil {
+				if onErr := sc.onError(sc, err); onErr != nil {
+					return false, onErr
+				}
+			}
+			return true, err
+		case err := <-readErrChan:
+			sc.closeConnection(conn, false)
+			return sc.handleReadError(ctx, err)
 		}
 	}
-	// if the running status is false, stop retrying
-	if atomic.LoadInt64(&sc.isRunning) == 0 {
-		return nil
+}
+
+// handleReadError decides whether the client reconnects after the connection failed.
+// When reconnecting, the read error is returned as the cause
+func (sc *SubscriptionClient) handleReadError(
+	ctx context.Context,
+	err error,
+) (bool, error) {
+	closeStatus := websocket.CloseStatus( //nolint:staticcheck // Library still functional
+		err,
+	)
+	if closeStatus == websocket.StatusNormalClosure {
+		// close event from websocket server, exiting...
+		return false, nil
 	}
 
-	return sc.Reset()
+	if closeStatus != -1 && sc.onReauthenticate != nil &&
+		sc.isAuthCloseCode(int(closeStatus)) {
+		sc.printLog(
+			fmt.Sprintf("%s. Re-authenticating...", err),
+			"client",
+			GQL_INTERNAL,
+		)
+		if authErr := sc.onReauthenticate(ctx, int(closeStatus)); authErr != nil {
+			if sc.onError != nil {
+				_ = sc.onError(sc, authErr)
+			}
+			return false, authErr
+		}
+		return true, err
+	}
+
+	sc.printLog(
+		fmt.Sprintf("%s. Retry connecting...", err),
+		"client",
+		GQL_INTERNAL,
+	)
+	if sc.onError != nil {
+		if onErr := sc.onError(sc, err); onErr != nil {
+			return false, onErr
+		}
+	}
+	return true, err
+}
+
+// closeConnection closes the connection if it's still the current one.
+// If graceful is true, running subscriptions are stopped and the connection is terminated before closing
+func (sc *SubscriptionClient) closeConnection(conn WebsocketConn, graceful bool) {
+	sc.mu.Lock()
+	if sc.conn != conn {
+		sc.mu.Unlock()
+		return
+	}
+	for id, sub := range sc.subscriptions {
+		if graceful && sub.started {
+			_ = sc.stopSubscription(id)
+		}
+		// started operations may have been executed, they aren't sent again
+		if sub.done != nil && sub.started {
+			delete(sc.subscriptions, id)
+			sub.handler(nil, ErrOperationInterrupted)
+		}
+		if sub.live != nil {
+			sub.live.reset()
+		}
+		sub.started = false
+	}
+	if graceful {
+		_ = sc.terminate()
+	}
+	sc.conn = nil
+	cancel := sc.cancel
+	sc.mu.Unlock()
+
+	_ = conn.Close()
+	if cancel != nil {
+		cancel()
+	}
+	if sc.onDisconnected != nil {
+		sc.onDisconnected()
+	}
 }
 
 // Unsubscribe sends stop message to server and close subscription channel
 // The input parameter is subscription ID that is returned from Subscribe function
 func (sc *SubscriptionClient) Unsubscribe(id string) error {
-	sc.subscribersMu.Lock()
-	defer sc.subscribersMu.Unlock()
+	sc.mu.Lock()
 
-	_, ok := sc.subscriptions[id]
+	// the shared operation is stopped with its last subscriber
+	if opID, ok := sc.sharedIDs[id]; ok {
+		if !sc.removeSharedSubscriber(id) {
+			sc.mu.Unlock()
+			return nil
+		}
+		id = opID
+	}
+
+	sub, ok := sc.subscriptions[id]
 	if !ok {
+		sc.mu.Unlock()
 		return fmt.Errorf("subscription id %s doesn't not exist", id)
 	}
 
 	delete(sc.subscriptions, id)
-	err := sc.stopSubscription(id)
-	if err != nil {
-		return err
+	if sub.shared != nil {
+		sc.forgetSharedOperation(id, sub.shared)
+	}
+	if sub.started {
+		if err := sc.stopSubscription(id); err != nil {
+			sc.mu.Unlock()
+			return err
+		}
 	}
 
-	// close the client if there is no running subscription
-	if len(sc.subscriptions) == 0 {
-		sc.printLog("no running subscription. exiting...", "client", GQL_INTERNAL)
-		return sc.Close()
+	if len(sc.subscriptions) > 0 {
+		sc.mu.Unlock()
+		return nil
 	}
-	return nil
+
+	// keep the lazy client connected until the idle timeout
+	if sc.lazy {
+		if sc.running {
+			sc.startIdleTimer()
+		}
+		sc.mu.Unlock()
+		return nil
+	}
+	sc.mu.Unlock()
+
+	// close the client if there is no running subscription
+	sc.printLog("no running subscription. exiting...", "client", GQL_INTERNAL)
+	return sc.Close()
 }
 
+// stopSubscription sends stop message to server. The caller must hold mu
 func (sc *SubscriptionClient) stopSubscription(id string) error {
 	if sc.conn != nil {
 		// send stop message to the server
@@ -674,7 +1765,7 @@ func (sc *SubscriptionClient) stopSubscription(id string) error {
 		}
 
 		sc.printLog(msg, "server", GQL_STOP)
-		if err := sc.writeJSON(msg); err != nil {
+		if err := sc.writeMessage(msg); err != nil {
 			return err
 		}
 
@@ -683,6 +1774,7 @@ func (sc *SubscriptionClient) stopSubscription(id string) error {
 	return nil
 }
 
+// terminate sends terminate message to server. The caller must hold mu
 func (sc *SubscriptionClient) terminate() error {
 	// send terminate message to the server
 	msg := OperationMessage{
@@ -691,62 +1783,96 @@ func (sc *SubscriptionClient) terminate() error {
 
 	if sc.conn != nil {
 		sc.printLog(msg, "client", GQL_CONNECTION_TERMINATE)
-		return sc.writeJSON(msg)
+		return sc.writeMessage(msg)
 	}
 
 	return nil
 }
 
 // Reset restart websocket connection and subscriptions
+// The connection is restarted asynchronously by the run loop. It does nothing if the client isn't running
 func (sc *SubscriptionClient) Reset() error {
-	if atomic.LoadInt64(&sc.isRunning) == 0 {
-		return nil
-	}
+	sc.mu.Lock()
+	running := sc.running
+	sc.mu.Unlock()
 
-	sc.subscribersMu.Lock()
-	for id, sub := range sc.subscriptions {
-		_ = sc.stopSubscription(id)
-		sub.started = false
+	if !running {
+		return nil
 	}
-	sc.subscribersMu.Unlock()
 
-	if sc.conn != nil {
-		_ = sc.terminate()
-		_ = sc.conn.Close()
-		sc.conn = nil
+	select {
+	case sc.resetChan <- struct{}{}:
+	default:
+		// a reset is already pending
 	}
-	sc.cancel()
-
-	return sc.Run()
+	return nil
 }
 
 // Close closes all subscription channel and websocket as well
 func (sc *SubscriptionClient) Close() (err error) {
-	sc.setIsRunning(false)
-	for id := range sc.subscriptions {
-		if err = sc.Unsubscribe(id); err != nil {
-			sc.cancel()
-			return
+	sc.mu.Lock()
+	for id, sub := range sc.subscriptions {
+		if sub.started {
+			_ = sc.stopSubscription(id)
 		}
+		delete(sc.subscriptions, id)
+		if sub.done != nil {
+			sub.handler(nil, ErrOperationInterrupted)
+		}
+	}
+	clear(sc.sharedKeys)
+	clear(sc.sharedIDs)
+	sc.stopIdleTimer()
+
+	conn, cancel := sc.conn, sc.cancel
+	if conn != nil {
+		err = sc.terminate()
+		sc.conn = nil
 	}
 
-	if terminateErr := sc.terminate(); terminateErr != nil {
-		err = terminateErr
+	sc.setState(ConnectionClosed)
+	if sc.runCancel != nil {
+		sc.runCancel()
 	}
-	if sc.conn != nil {
-		if closeErr := sc.conn.Close(); closeErr != nil && err == nil {
+	sc.mu.Unlock()
+
+	if conn != nil {
+		if closeErr := conn.Close(); closeErr != nil && err == nil {
 			err = closeErr
 		}
-		sc.conn = nil
 		if sc.onDisconnected != nil {
 			sc.onDisconnected()
 		}
 	}
-	sc.cancel()
+	if cancel != nil {
+		cancel()
+	}
 
 	return
 }
 
+// Shutdown gracefully stops the client. It stops all subscriptions, terminates the connection,
+// then waits for the reader goroutine and in-flight handlers to finish.
+// If ctx is done before, Shutdown returns the context error
+func (sc *SubscriptionClient) Shutdown(ctx context.Context) error {
+	err := sc.Close()
+
+	done := make(chan struct{})
+	go func() {
+		// the readers dispatch the handlers, so they're awaited first
+		sc.readersWg.Wait()
+		sc.handlersWg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("shutdown timeout: %w", ctx.Err())
+	}
+}
+
 // default websocket handler implementation using https://github.com/nhooyr/websocket
 type WebsocketHandler struct {
 	ctx     context.Context
@@ -775,14 +1901,29 @@ func (wh *WebsocketHandler) Close() error {
 }
 
 func newWebsocketConn(sc *SubscriptionClient) (WebsocketConn, error) {
+	ctx := sc.GetContext()
+	header, err := sc.websocketOptions.header(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("websocket header: %w", err)
+	}
 
 	options := &websocket.DialOptions{ //nolint:staticcheck // Library still functional
-		Subprotocols: []string{"graphql-ws"},
-		HTTPClient:   sc.websocketOptions.HTTPClient,
+		Subprotocols:         sc.subprotocols(),
+		HTTPClient:           sc.websocketOptions.HTTPClient,
+		HTTPHeader:           header,
+		CompressionMode:      sc.websocketOptions.CompressionMode.websocketMode(),
+		CompressionThreshold: sc.websocketOptions.CompressionThreshold,
+	}
+
+	dialCtx := ctx
+	if sc.websocketOptions.DialTimeout > 0 {
+		var cancel context.CancelFunc
+		dialCtx, cancel = context.WithTimeout(ctx, sc.websocketOptions.DialTimeout)
+		defer cancel()
 	}
 
 	c, _, err := websocket.Dial( //nolint:staticcheck // Library still functional
-		sc.GetContext(),
+		dialCtx,
 		sc.GetURL(),
 		options,
 	)
@@ -791,14 +1932,76 @@ func newWebsocketConn(sc *SubscriptionClient) (WebsocketConn, error) {
 	}
 
 	return &WebsocketHandler{
-		ctx:     sc.GetContext(),
+		ctx:     ctx,
 		Conn:    c,
 		timeout: sc.GetTimeout(),
 	}, nil
 }
 
+// CompressionMode represents the permessage-deflate compression mode negotiated by the websocket client
+type CompressionMode int
+
+const (
+	// CompressionDisabled disables the negotiation of the permessage-deflate extension. This is the default
+	CompressionDisabled CompressionMode = iota
+	// CompressionContextTakeover compresses messages reusing the sliding window of previous messages.
+	// It uses more memory than CompressionNoContextTakeover but compresses more efficiently
+	CompressionContextTakeover
+	// CompressionNoContextTakeover compresses each message independently
+	CompressionNoContextTakeover
+)
+
+func (cm CompressionMode) websocketMode() websocket.CompressionMode { //nolint:staticcheck // Library still functional
+	switch cm {
+	case CompressionContextTakeover:
+		return websocket.CompressionContextTakeover //nolint:staticcheck // Library still functional
+	case CompressionNoContextTakeover:
+		return websocket.CompressionNoContextTakeover //nolint:staticcheck // Library still functional
+	default:
+		return websocket.CompressionDisabled //nolint:staticcheck // Library still functional
+	}
+}
+
 // WebsocketOptions allows implementation agnostic configuration of the websocket client
 type WebsocketOptions struct {
 	// HTTPClient is used for the connection.
 	HTTPClient *http.Client
+	// Header specifies the HTTP headers included in the handshake request,
+	// e.g. Authorization, Origin or Cookie.
+	Header http.Header
+	// HeaderProvider is called before every connection attempt, including reconnections.
+	// The returned headers are added to Header, replacing the values of the same keys.
+	// Returning an error aborts the connection attempt.
+	HeaderProvider func(ctx context.Context) (http.Header, error)
+	// Subprotocols lists the WebSocket subprotocols to negotiate with the server,
+	// in addition to the subprotocols of the protocols set with WithProtocols.
+	Subprotocols []string
+	// CompressionMode controls the permessage-deflate compression mode.
+	// Defaults to CompressionDisabled.
+	CompressionMode CompressionMode
+	// CompressionThreshold controls the minimum size in bytes of a message before compression is applied.
+	// Defaults to 128 bytes for CompressionContextTakeover and 512 bytes for CompressionNoContextTakeover.
+	CompressionThreshold int
+	// DialTimeout bounds the time spent opening the connection, including the handshake.
+	// Zero means no timeout other than the one of the HTTP client.
+	DialTimeout time.Duration
+}
+
+// header merges the static headers with the headers of the provider
+func (wo WebsocketOptions) header(ctx context.Context) (http.Header, error) {
+	header := wo.Header.Clone()
+	if wo.HeaderProvider == nil {
+		return header, nil
+	}
+	provided, err := wo.HeaderProvider(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if header == nil {
+		header = make(http.Header, len(provided))
+	}
+	for key, values := range provided {
+		header[http.CanonicalHeaderKey(key)] = append([]string(nil), values...)
+	}
+	return header, nil
 }
@@ -4,11 +4,15 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"math/rand"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -649,3 +653,1419 @@ func TestSubscriptionClient_Reset(t *testing.T) {
 	// Reset should reset the client to initial state
 	// No error expected
 }
+
+// newTestWebsocketServer starts a websocket server which runs fn for each accepted connection
+func newTestWebsocketServer(
+	t *testing.T,
+	fn func(c *websocket.Conn),
+) (*httptest.Server, string) {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{Subprotocols: []string{"graphql-ws"}}
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer func() { _ = c.Close() }()
+		fn(c)
+	}))
+	t.Cleanup(server.Close)
+
+	return server, "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func TestSubscriptionClient_ConnectionRejected(t *testing.T) {
+	_, wsURL := newTestWebsocketServer(t, func(c *websocket.Conn) {
+		// Read connection init
+		_, _, _ = c.ReadMessage()
+		_ = c.WriteJSON(map[string]any{
+			"type":    string(GQL_CONNECTION_ERROR),
+			"payload": map[string]string{"message": "invalid token"},
+		})
+		time.Sleep(time.Second)
+	})
+
+	handlerErr := make(chan error, 1)
+	client := NewSubscriptionClient(wsURL).
+		WithTimeout(2 * time.Second).
+		OnError(func(sc *SubscriptionClient, err error) error {
+			return err
+		})
+	defer func() { _ = client.Close() }()
+
+	_, err := client.Exec(`subscription { test }`, nil, func(message []byte, err error) error {
+		handlerErr <- err
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("got error: %v, want: nil", err)
+	}
+
+	err = client.Run()
+	var rejectedErr *ConnectionRejectedError
+	if !errors.As(err, &rejectedErr) {
+		t.Fatalf("got error: %v, want: *ConnectionRejectedError", err)
+	}
+	if string(rejectedErr.Payload) != `{"message":"invalid token"}` {
+		t.Errorf("unexpected payload: %s", rejectedErr.Payload)
+	}
+
+	select {
+	case err := <-handlerErr:
+		if !errors.As(err, &rejectedErr) {
+			t.Errorf("handler got error: %v, want: *ConnectionRejectedError", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("handler wasn't notified of the rejected connection")
+	}
+}
+
+func TestSubscriptionClient_OperationError(t *testing.T) {
+	_, wsURL := newTestWebsocketServer(t, func(c *websocket.Conn) {
+		// Read connection init
+		_, _, _ = c.ReadMessage()
+		_ = c.WriteJSON(map[string]string{"type": string(GQL_CONNECTION_ACK)})
+
+		var start OperationMessage
+		if err := c.ReadJSON(&start); err != nil {
+			return
+		}
+		_ = c.WriteJSON(map[string]any{
+			"id":      start.ID,
+			"type":    string(GQL_ERROR),
+			"payload": map[string]string{"message": "unknown field test"},
+		})
+		time.Sleep(time.Second)
+	})
+
+	handlerErr := make(chan error, 1)
+	client := NewSubscriptionClient(wsURL).
+		WithTimeout(2 * time.Second).
+		OnError(func(sc *SubscriptionClient, err error) error {
+			return err
+		})
+	defer func() { _ = client.Close() }()
+
+	id, err := client.Exec(`subscription { test }`, nil, func(message []byte, err error) error {
+		handlerErr <- err
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("got error: %v, want: nil", err)
+	}
+
+	err = client.Run()
+	var subErr *SubscriptionError
+	if !errors.As(err, &subErr) {
+		t.Fatalf("got error: %v, want: *SubscriptionError", err)
+	}
+	if subErr.ID != id {
+		t.Errorf("got subscription id: %s, want: %s", subErr.ID, id)
+	}
+	if len(subErr.Errors) != 1 || subErr.Errors[0].Message != "unknown field test" {
+		t.Errorf("unexpected errors: %+v", subErr.Errors)
+	}
+
+	select {
+	case err := <-handlerErr:
+		if !errors.As(err, &subErr) {
+			t.Errorf("handler got error: %v, want: *SubscriptionError", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("handler wasn't notified of the operation error")
+	}
+}
+
+func TestSubscriptionClient_OperationErrorReportedOnce(t *testing.T) {
+	received := make(chan OperationMessage, 10)
+	_, wsURL := newTestWebsocketServer(t, func(c *websocket.Conn) {
+		_, _, _ = c.ReadMessage()
+		_ = c.WriteJSON(map[string]string{"type": string(GQL_CONNECTION_ACK)})
+
+		for {
+			var msg OperationMessage
+			if err := c.ReadJSON(&msg); err != nil {
+				return
+			}
+			received <- msg
+			if msg.Type == GQL_START {
+				_ = c.WriteJSON(map[string]any{
+					"id":      msg.ID,
+					"type":    string(GQL_ERROR),
+					"payload": map[string]string{"message": "unknown field test"},
+				})
+			}
+		}
+	})
+
+	reported := make(chan error, 10)
+	client := NewSubscriptionClient(wsURL).
+		WithTimeout(2 * time.Second).
+		OnError(func(sc *SubscriptionClient, err error) error {
+			reported <- err
+			return nil
+		})
+	defer func() { _ = client.Close() }()
+
+	handled := make(chan struct{}, 1)
+	id, err := client.Exec(`subscription { test }`, nil, func(message []byte, err error) error {
+		handled <- struct{}{}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("got error: %v, want: nil", err)
+	}
+	go func() { _ = client.Run() }()
+
+	select {
+	case <-handled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler wasn't notified of the operation error")
+	}
+	select {
+	case <-reported:
+	case <-time.After(time.Second):
+		t.Fatal("the operation error wasn't reported")
+	}
+	select {
+	case err := <-reported:
+		t.Errorf("got error reported twice: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	infos := client.Subscriptions()
+	if len(infos) != 1 || infos[0].State != SubscriptionFailed {
+		t.Fatalf("got subscriptions: %+v, want: 1 failed subscription", infos)
+	}
+
+	// the failed operation isn't stopped
+	if err := client.Unsubscribe(id); err != nil {
+		t.Fatalf("got error: %v, want: nil", err)
+	}
+	if msg := <-received; msg.Type != GQL_START {
+		t.Fatalf("got message: %s, want: %s", msg.Type, GQL_START)
+	}
+	select {
+	case msg := <-received:
+		if msg.Type == GQL_STOP {
+			t.Errorf("got stop message for the failed operation")
+		}
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestSubscriptionClient_ConnectionAckTimeout(t *testing.T) {
+	_, wsURL := newTestWebsocketServer(t, func(c *websocket.Conn) {
+		// Read connection init and never acknowledge it
+		_, _, _ = c.ReadMessage()
+		time.Sleep(time.Second)
+	})
+
+	client := NewSubscriptionClient(wsURL).
+		WithTimeout(2 * time.Second).
+		WithConnectionAckTimeout(100 * time.Millisecond).
+		OnError(func(sc *SubscriptionClient, err error) error {
+			return err
+		})
+	defer func() { _ = client.Close() }()
+
+	if err := client.Run(); !errors.Is(err, ErrConnectionAckTimeout) {
+		t.Fatalf("got error: %v, want: %v", err, ErrConnectionAckTimeout)
+	}
+}
+
+func TestSubscriptionClient_ConnectionAckTimeoutReconnect(t *testing.T) {
+	var connections atomic.Int32
+	_, wsURL := newTestWebsocketServer(t, func(c *websocket.Conn) {
+		n := connections.Add(1)
+		_, _, _ = c.ReadMessage()
+		// the first connection is never acknowledged
+		if n > 1 {
+			_ = c.WriteJSON(map[string]string{"type": string(GQL_CONNECTION_ACK)})
+		}
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+
+	var timeouts atomic.Int32
+	client := NewSubscriptionClient(wsURL).
+		WithTimeout(2 * time.Second).
+		WithConnectionAckTimeout(100 * time.Millisecond).
+		OnError(func(sc *SubscriptionClient, err error) error {
+			if errors.Is(err, ErrConnectionAckTimeout) {
+				timeouts.Add(1)
+			}
+			return nil
+		})
+	defer func() { _ = client.Close() }()
+
+	go func() { _ = client.Run() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := client.WaitForState(ctx, ConnectionConnected); err != nil {
+		t.Fatalf("got error: %v, want: the client reconnects", err)
+	}
+	if got := connections.Load(); got != 2 {
+		t.Errorf("got %d connections, want: 2", got)
+	}
+	// the timeout of the first connection isn't reported again on the next one
+	time.Sleep(200 * time.Millisecond)
+	if got := timeouts.Load(); got != 1 {
+		t.Errorf("got %d timeouts, want: 1", got)
+	}
+}
+
+func TestParseOperationErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		want    int
+	}{
+		{"single error", `{"message":"a"}`, 1},
+		{"error list", `[{"message":"a"},{"message":"b"}]`, 2},
+		{"errors field", `{"errors":[{"message":"a"}]}`, 1},
+		{"empty payload", ``, 0},
+		{"unknown payload", `"oops"`, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseOperationErrors(json.RawMessage(tt.payload))
+			if len(got) != tt.want {
+				t.Errorf("got %d errors, want: %d", len(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestSubscriptionClient_ConnectionParamsFn(t *testing.T) {
+	tokens := make(chan string, 10)
+	_, wsURL := newTestWebsocketServer(t, func(c *websocket.Conn) {
+		var init struct {
+			Payload struct {
+				Token string `json:"token"`
+			} `json:"payload"`
+		}
+		if err := c.ReadJSON(&init); err != nil {
+			return
+		}
+		tokens <- init.Payload.Token
+
+		if init.Payload.Token != "token-2" {
+			_ = c.WriteMessage(
+				websocket.CloseMessage,
+				websocket.FormatCloseMessage(StatusUnauthorized, "token expired"),
+			)
+			time.Sleep(100 * time.Millisecond)
+			return
+		}
+		_ = c.WriteJSON(map[string]string{"type": string(GQL_CONNECTION_ACK)})
+		time.Sleep(time.Second)
+	})
+
+	var version atomic.Int32
+	version.Store(1)
+	reauthCodes := make(chan int, 10)
+	connected := make(chan struct{}, 1)
+	client := NewSubscriptionClient(wsURL).
+		WithTimeout(2 * time.Second).
+		WithConnectionParamsFn(func(ctx context.Context) (map[string]any, error) {
+			return map[string]any{"token": fmt.Sprintf("token-%d", version.Load())}, nil
+		}).
+		OnReauthenticate(func(ctx context.Context, code int) error {
+			reauthCodes <- code
+			version.Add(1)
+			return nil
+		}).
+		OnConnected(func() {
+			connected <- struct{}{}
+		})
+
+	go func() { _ = client.Run() }()
+
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("client didn't reconnect with refreshed params")
+	}
+	_ = client.Close()
+
+	if got := <-tokens; got != "token-1" {
+		t.Errorf("got token: %s, want: token-1", got)
+	}
+	if got := <-tokens; got != "token-2" {
+		t.Errorf("got token: %s, want: token-2", got)
+	}
+	if got := <-reauthCodes; got != StatusUnauthorized {
+		t.Errorf("got close code: %d, want: %d", got, StatusUnauthorized)
+	}
+}
+
+func TestSubscriptionClient_ReauthenticateError(t *testing.T) {
+	_, wsURL := newTestWebsocketServer(t, func(c *websocket.Conn) {
+		_, _, _ = c.ReadMessage()
+		_ = c.WriteMessage(
+			websocket.CloseMessage,
+			websocket.FormatCloseMessage(StatusForbidden, "forbidden"),
+		)
+		time.Sleep(100 * time.Millisecond)
+	})
+
+	errReauth := errors.New("no credentials")
+	client := NewSubscriptionClient(wsURL).
+		WithTimeout(2 * time.Second).
+		OnReauthenticate(func(ctx context.Context, code int) error {
+			return errReauth
+		}).
+		OnError(func(sc *SubscriptionClient, err error) error {
+			return err
+		})
+	defer func() { _ = client.Close() }()
+
+	if err := client.Run(); !errors.Is(err, errReauth) {
+		t.Fatalf("got error: %v, want: %v", err, errReauth)
+	}
+}
+
+func TestSubscriptionClient_LazyConnect(t *testing.T) {
+	events := make(chan string, 20)
+	_, wsURL := newTestWebsocketServer(t, func(c *websocket.Conn) {
+		events <- "connect"
+		for {
+			var msg OperationMessage
+			if err := c.ReadJSON(&msg); err != nil {
+				events <- "disconnect"
+				return
+			}
+			switch msg.Type {
+			case GQL_CONNECTION_INIT:
+				_ = c.WriteJSON(map[string]string{"type": string(GQL_CONNECTION_ACK)})
+			case GQL_START, GQL_STOP:
+				events <- string(msg.Type)
+			}
+		}
+	})
+
+	expectEvent := func(want string) {
+		t.Helper()
+		select {
+		case got := <-events:
+			if got != want {
+				t.Fatalf("got event: %s, want: %s", got, want)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("timed out waiting for event: %s", want)
+		}
+	}
+
+	client := NewSubscriptionClient(wsURL).
+		WithTimeout(2 * time.Second).
+		WithLazyConnect(200 * time.Millisecond)
+	defer func() { _ = client.Close() }()
+
+	handler := func(message []byte, err error) error { return nil }
+
+	// the connection is opened on the first subscription
+	id, err := client.Exec(`subscription { test }`, nil, handler)
+	if err != nil {
+		t.Fatalf("got error: %v, want: nil", err)
+	}
+	expectEvent("connect")
+	expectEvent(string(GQL_START))
+
+	// the connection is kept alive during the idle timeout
+	if err := client.Unsubscribe(id); err != nil {
+		t.Fatalf("got error: %v, want: nil", err)
+	}
+	expectEvent(string(GQL_STOP))
+	id, err = client.Exec(`subscription { test }`, nil, handler)
+	if err != nil {
+		t.Fatalf("got error: %v, want: nil", err)
+	}
+	expectEvent(string(GQL_START))
+
+	// the connection is closed after the idle timeout
+	if err := client.Unsubscribe(id); err != nil {
+		t.Fatalf("got error: %v, want: nil", err)
+	}
+	expectEvent(string(GQL_STOP))
+	expectEvent("disconnect")
+
+	// the connection is reopened on the next subscription
+	if _, err := client.Exec(`subscription { test }`, nil, handler); err != nil {
+		t.Fatalf("got error: %v, want: nil", err)
+	}
+	expectEvent("connect")
+	expectEvent(string(GQL_START))
+}
+
+func TestSubscriptionClient_RunWithContext(t *testing.T) {
+	disconnected := make(chan struct{})
+	_, wsURL := newTestWebsocketServer(t, func(c *websocket.Conn) {
+		_, _, _ = c.ReadMessage()
+		_ = c.WriteJSON(map[string]string{"type": string(GQL_CONNECTION_ACK)})
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				close(disconnected)
+				return
+			}
+		}
+	})
+
+	client := NewSubscriptionClient(wsURL).WithTimeout(5 * time.Second)
+	if _, err := client.Exec(`subscription { test }`, nil, func(message []byte, err error) error {
+		return nil
+	}); err != nil {
+		t.Fatalf("got error: %v, want: nil", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() {
+		result <- client.RunWithContext(ctx)
+	}()
+
+	time.Sleep(200 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Fatalf("got error: %v, want: nil", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("RunWithContext didn't stop after the context was cancelled")
+	}
+
+	select {
+	case <-disconnected:
+	case <-time.After(3 * time.Second):
+		t.Fatal("the connection wasn't closed after the context was cancelled")
+	}
+}
+
+func TestSubscriptionClient_Shutdown(t *testing.T) {
+	messages := make(chan OperationMessage, 10)
+	_, wsURL := newTestWebsocketServer(t, func(c *websocket.Conn) {
+		for {
+			var msg OperationMessage
+			if err := c.ReadJSON(&msg); err != nil {
+				return
+			}
+			messages <- msg
+			switch msg.Type {
+			case GQL_CONNECTION_INIT:
+				_ = c.WriteJSON(map[string]string{"type": string(GQL_CONNECTION_ACK)})
+			case GQL_START:
+				_ = c.WriteJSON(map[string]any{
+					"id":      msg.ID,
+					"type":    string(GQL_DATA),
+					"payload": map[string]any{"data": map[string]int{"test": 1}},
+				})
+			}
+		}
+	})
+
+	started := make(chan struct{})
+	var handled atomic.Bool
+	client := NewSubscriptionClient(wsURL).WithTimeout(5 * time.Second)
+	if _, err := client.Exec(`subscription { test }`, nil, func(message []byte, err error) error {
+		close(started)
+		// simulate a slow handler
+		time.Sleep(300 * time.Millisecond)
+		handled.Store(true)
+		return nil
+	}); err != nil {
+		t.Fatalf("got error: %v, want: nil", err)
+	}
+
+	go func() { _ = client.Run() }()
+
+	select {
+	case <-started:
+	case <-time.After(3 * time.Second):
+		t.Fatal("handler wasn't called")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := client.Shutdown(ctx); err != nil {
+		t.Fatalf("got error: %v, want: nil", err)
+	}
+	if !handled.Load() {
+		t.Error("Shutdown returned before the in-flight handler finished")
+	}
+
+	want := []OperationMessageType{
+		GQL_CONNECTION_INIT,
+		GQL_START,
+		GQL_STOP,
+		GQL_CONNECTION_TERMINATE,
+	}
+	for _, w := range want {
+		select {
+		case msg := <-messages:
+			if msg.Type != w {
+				t.Fatalf("got message: %s, want: %s", msg.Type, w)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for message: %s", w)
+		}
+	}
+}
+
+func TestSubscriptionClient_ShutdownTimeout(t *testing.T) {
+	_, wsURL := newTestWebsocketServer(t, func(c *websocket.Conn) {
+		var msg OperationMessage
+		_ = c.ReadJSON(&msg)
+		_ = c.WriteJSON(map[string]string{"type": string(GQL_CONNECTION_ACK)})
+		if err := c.ReadJSON(&msg); err != nil {
+			return
+		}
+		_ = c.WriteJSON(map[string]any{
+			"id":      msg.ID,
+			"type":    string(GQL_DATA),
+			"payload": map[string]any{"data": map[string]int{"test": 1}},
+		})
+		time.Sleep(time.Second)
+	})
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	client := NewSubscriptionClient(wsURL).WithTimeout(5 * time.Second)
+	if _, err := client.Exec(`subscription { test }`, nil, func(message []byte, err error) error {
+		close(started)
+		<-release
+		return nil
+	}); err != nil {
+		t.Fatalf("got error: %v, want: nil", err)
+	}
+
+	go func() { _ = client.Run() }()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := client.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error: %v, want: %v", err, context.DeadlineExceeded)
+	}
+}
+
+// serveTestSubscriptions acknowledges the connection and replies one data message to every start message
+func serveTestSubscriptions(c *websocket.Conn) {
+	for {
+		var msg OperationMessage
+		if err := c.ReadJSON(&msg); err != nil {
+			return
+		}
+		switch msg.Type {
+		case GQL_CONNECTION_INIT:
+			_ = c.WriteJSON(map[string]string{"type": string(GQL_CONNECTION_ACK)})
+		case GQL_START:
+			_ = c.WriteJSON(map[string]any{
+				"id":      msg.ID,
+				"type":    string(GQL_DATA),
+				"payload": map[string]any{"data": map[string]int{"test": 1}},
+			})
+		case GQL_CONNECTION_TERMINATE:
+			return
+		}
+	}
+}
+
+func TestSubscriptionClient_State(t *testing.T) {
+	_, wsURL := newTestWebsocketServer(t, serveTestSubscriptions)
+
+	var connectedCount atomic.Int32
+	client := NewSubscriptionClient(wsURL).
+		WithTimeout(5 * time.Second).
+		OnConnected(func() {
+			connectedCount.Add(1)
+		})
+	if got := client.State(); got != ConnectionIdle {
+		t.Fatalf("got state: %s, want: %s", got, ConnectionIdle)
+	}
+
+	if _, err := client.Exec(`subscription { test }`, nil, func(message []byte, err error) error {
+		return nil
+	}); err != nil {
+		t.Fatalf("got error: %v, want: nil", err)
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		result <- client.Run()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.WaitForState(ctx, ConnectionConnected); err != nil {
+		t.Fatalf("got error: %v, want: nil", err)
+	}
+
+	if err := client.Run(); err == nil {
+		t.Error("expected error when running the client twice")
+	}
+
+	// reset reconnects the client
+	if err := client.Reset(); err != nil {
+		t.Fatalf("got error: %v, want: nil", err)
+	}
+	for connectedCount.Load() < 2 {
+		if err := client.WaitForState(ctx, ConnectionReconnecting); err != nil {
+			t.Fatalf("got error: %v, want: nil", err)
+		}
+		if err := client.WaitForState(ctx, ConnectionConnected); err != nil {
+			t.Fatalf("got error: %v, want: nil", err)
+		}
+	}
+
+	if err := client.Close(); err != nil {
+		t.Fatalf("got error: %v, want: nil", err)
+	}
+	if got := client.State(); got != ConnectionClosed {
+		t.Errorf("got state: %s, want: %s", got, ConnectionClosed)
+	}
+
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Fatalf("got error: %v, want: nil", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Run didn't return after Close")
+	}
+	if got := client.State(); got != ConnectionClosed {
+		t.Errorf("got state: %s, want: %s", got, ConnectionClosed)
+	}
+}
+
+// TestSubscriptionClient_ConcurrentOperations is meant to be run with the race detector
+func TestSubscriptionClient_ConcurrentOperations(t *testing.T) {
+	_, wsURL := newTestWebsocketServer(t, serveTestSubscriptions)
+
+	client := NewSubscriptionClient(wsURL).
+		WithTimeout(5 * time.Second).
+		OnError(func(sc *SubscriptionClient, err error) error {
+			return nil
+		})
+
+	handler := func(message []byte, err error) error {
+		return nil
+	}
+
+	// keep a subscription, so the client isn't closed when the others are stopped
+	if _, err := client.Exec(`subscription { test }`, nil, handler); err != nil {
+		t.Fatalf("got error: %v, want: nil", err)
+	}
+
+	go func() { _ = client.Run() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := client.WaitForState(ctx, ConnectionConnected); err != nil {
+		t.Fatalf("got error: %v, want: nil", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				id, err := client.Exec(`subscription { test }`, nil, handler)
+				if err != nil {
+					// writes may fail while the connection is reset
+					continue
+				}
+				if (i+j)%7 == 0 {
+					_ = client.Reset()
+				}
+				_ = client.State()
+				_ = client.Unsubscribe(id)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if err := client.WaitForState(ctx, ConnectionConnected); err != nil {
+		t.Fatalf("got error: %v, want: nil", err)
+	}
+	if err := client.Shutdown(ctx); err != nil {
+		t.Fatalf("got error: %v, want: nil", err)
+	}
+	if got := client.State(); got != ConnectionClosed {
+		t.Errorf("got state: %s, want: %s", got, ConnectionClosed)
+	}
+}
+
+func TestSubscriptionClient_WebsocketOptions(t *testing.T) {
+	type handshake struct {
+		header       http.Header
+		subprotocols []string
+		extensions   string
+	}
+	handshakes := make(chan handshake, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handshakes <- handshake{
+			header:       r.Header.Clone(),
+			subprotocols: websocket.Subprotocols(r),
+			extensions:   r.Header.Get("Sec-WebSocket-Extensions"),
+		}
+		upgrader := websocket.Upgrader{
+			Subprotocols: []string{"graphql-ws"},
+			CheckOrigin: func(r *http.Request) bool {
+				return true
+			},
+		}
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer func() { _ = c.Close() }()
+		serveTestSubscriptions(c)
+	}))
+	defer server.Close()
+
+	var providerCalls atomic.Int32
+	client := NewSubscriptionClient("ws" + strings.TrimPrefix(server.URL, "http")).
+		WithTimeout(5 * time.Second).
+		WithWebSocketOptions(WebsocketOptions{
+			Header: http.Header{
+				"Origin":        []string{"https://example.com"},
+				"Authorization": []string{"Bearer static"},
+			},
+			HeaderProvider: func(ctx context.Context) (http.Header, error) {
+				n := providerCalls.Add(1)
+				return http.Header{
+					"authorization": []string{fmt.Sprintf("Bearer token-%d", n)},
+				}, nil
+			},
+			Subprotocols:    []string{"graphql-transport-ws"},
+			CompressionMode: CompressionContextTakeover,
+			DialTimeout:     5 * time.Second,
+		})
+
+	if _, err := client.Exec(`subscription { test }`, nil, func(message []byte, err error) error {
+		return nil
+	}); err != nil {
+		t.Fatalf("got error: %v, want: nil", err)
+	}
+	go func() { _ = client.Run() }()
+	defer func() { _ = client.Close() }()
+
+	for i := 1; i <= 2; i++ {
+		var h handshake
+		select {
+		case h = <-handshakes:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("connection %d wasn't opened", i)
+		}
+		if got := h.header.Get("Origin"); got != "https://example.com" {
+			t.Errorf("got Origin: %s, want: https://example.com", got)
+		}
+		if got, want := h.header.Values("Authorization"), []string{fmt.Sprintf("Bearer token-%d", i)}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got Authorization: %v, want: %v", got, want)
+		}
+		if want := []string{"graphql-ws", "graphql-transport-ws"}; !reflect.DeepEqual(h.subprotocols, want) {
+			t.Errorf("got subprotocols: %v, want: %v", h.subprotocols, want)
+		}
+		if !strings.Contains(h.extensions, "permessage-deflate") {
+			t.Errorf("got extensions: %s, want permessage-deflate", h.extensions)
+		}
+
+		if i == 1 {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			err := client.WaitForState(ctx, ConnectionConnected)
+			cancel()
+			if err != nil {
+				t.Fatalf("got error: %v, want: nil", err)
+			}
+			// the header provider is evaluated again on reconnection
+			if err := client.Reset(); err != nil {
+				t.Fatalf("got error: %v, want: nil", err)
+			}
+		}
+	}
+}
+
+func TestSubscriptionClient_WebsocketHeaderProviderError(t *testing.T) {
+	_, wsURL := newTestWebsocketServer(t, serveTestSubscriptions)
+
+	providerErr := errors.New("token unavailable")
+	client := NewSubscriptionClient(wsURL).
+		WithRetryTimeout(0).
+		WithWebSocketOptions(WebsocketOptions{
+			HeaderProvider: func(ctx context.Context) (http.Header, error) {
+				return nil, providerErr
+			},
+		})
+	if _, err := client.Exec(`subscription { test }`, nil, func(message []byte, err error) error {
+		return nil
+	}); err != nil {
+		t.Fatalf("got error: %v, want: nil", err)
+	}
+
+	result := make(chan error, 1)
+	go func() { result <- client.Run() }()
+
+	select {
+	case err := <-result:
+		if !errors.Is(err, providerErr) {
+			t.Fatalf("got error: %v, want: %v", err, providerErr)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run didn't return")
+	}
+}
+
+func TestSubscriptionClient_QueryMutate(t *testing.T) {
+	stopped := make(chan string, 10)
+	_, wsURL := newTestWebsocketServer(t, func(c *websocket.Conn) {
+		for {
+			var msg OperationMessage
+			if err := c.ReadJSON(&msg); err != nil {
+				return
+			}
+			switch msg.Type {
+			case GQL_CONNECTION_INIT:
+				_ = c.WriteJSON(map[string]string{"type": string(GQL_CONNECTION_ACK)})
+			case GQL_START:
+				var payload struct {
+					Query     string
+					Variables map[string]any
+				}
+				_ = json.Unmarshal(msg.Payload, &payload)
+				switch {
+				case strings.Contains(payload.Query, "slow"):
+					// never answers
+				case strings.Contains(payload.Query, "partial"):
+					_ = c.WriteJSON(map[string]any{
+						"id":   msg.ID,
+						"type": string(GQL_DATA),
+						"payload": map[string]any{
+							"data":   map[string]any{"hello": "world", "partial": nil},
+							"errors": []map[string]string{{"message": "partial failed"}},
+						},
+					})
+					_ = c.WriteJSON(map[string]any{
+						"id":   msg.ID,
+						"type": string(GQL_COMPLETE),
+					})
+				case strings.HasPrefix(payload.Query, "mutation"):
+					_ = c.WriteJSON(map[string]any{
+						"id":      msg.ID,
+						"type":    string(GQL_ERROR),
+						"payload": map[string]string{"message": "read only"},
+					})
+				default:
+					_ = c.WriteJSON(map[string]any{
+						"id":      msg.ID,
+						"type":    string(GQL_DATA),
+						"payload": map[string]any{"data": map[string]any{"hello": payload.Variables["name"]}},
+					})
+					_ = c.WriteJSON(map[string]any{
+						"id":   msg.ID,
+						"type": string(GQL_COMPLETE),
+					})
+				}
+			case GQL_STOP:
+				stopped <- msg.ID
+			case GQL_CONNECTION_TERMINATE:
+				return
+			}
+		}
+	})
+
+	client := NewSubscriptionClient(wsURL).
+		WithTimeout(5 * time.Second).
+		OnError(func(sc *SubscriptionClient, err error) error {
+			t.Errorf("got error: %v, want: nil", err)
+			return nil
+		})
+	defer func() { _ = client.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// the operation doesn't wait for a client which isn't running
+	var notRunning struct {
+		Hello string
+	}
+	if err := client.Query(ctx, &notRunning, nil); !errors.Is(err, ErrClientNotRunning) {
+		t.Errorf("got error: %v, want: %v", err, ErrClientNotRunning)
+	}
+
+	go func() { _ = client.Run() }()
+	if err := client.WaitForState(ctx, ConnectionConnected); err != nil {
+		t.Fatalf("got error: %v, want: nil", err)
+	}
+
+	var q struct {
+		Hello string `graphql:"hello(name: $name)"`
+	}
+	if err := client.Query(ctx, &q, map[string]any{"name": String("world")}); err != nil {
+		t.Fatalf("got error: %v, want: nil", err)
+	}
+	if q.Hello != "world" {
+		t.Errorf("got hello: %s, want: world", q.Hello)
+	}
+
+	// the client isn't closed after the last operation
+	if got := client.State(); got != ConnectionConnected {
+		t.Errorf("got state: %s, want: %s", got, ConnectionConnected)
+	}
+
+	var m struct {
+		Update bool `graphql:"update"`
+	}
+	err := client.Mutate(ctx, &m, nil)
+	var subErr *SubscriptionError
+	if !errors.As(err, &subErr) || subErr.Errors[0].Message != "read only" {
+		t.Errorf("got error: %v, want: read only", err)
+	}
+	// the operation ended by the server isn't stopped
+	select {
+	case id := <-stopped:
+		t.Errorf("got stop message for the failed operation %s", id)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// the partial data is decoded with the errors
+	var partial struct {
+		Hello   string
+		Partial *string
+	}
+	err = client.Query(ctx, &partial, nil)
+	var errs Errors
+	if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Message != "partial failed" {
+		t.Errorf("got error: %v, want: partial failed", err)
+	}
+	if partial.Hello != "world" {
+		t.Errorf("got hello: %s, want: world", partial.Hello)
+	}
+
+	var slow struct {
+		Slow bool `graphql:"slow"`
+	}
+	timeoutCtx, timeoutCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer timeoutCancel()
+	if err := client.Query(timeoutCtx, &slow, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error: %v, want: %v", err, context.DeadlineExceeded)
+	}
+	// the operation is stopped when the context is done
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Error("the operation wasn't stopped")
+	}
+}
+
+func TestSubscriptionClient_QueryInterrupted(t *testing.T) {
+	_, wsURL := newTestWebsocketServer(t, func(c *websocket.Conn) {
+		for {
+			var msg OperationMessage
+			if err := c.ReadJSON(&msg); err != nil {
+				return
+			}
+			switch msg.Type {
+			case GQL_CONNECTION_INIT:
+				_ = c.WriteJSON(map[string]string{"type": string(GQL_CONNECTION_ACK)})
+			case GQL_START:
+				// drop the connection before answering
+				return
+			}
+		}
+	})
+
+	client := NewSubscriptionClient(wsURL).
+		WithTimeout(5 * time.Second).
+		OnError(func(sc *SubscriptionClient, err error) error {
+			return nil
+		})
+	go func() { _ = client.Run() }()
+	defer func() { _ = client.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.WaitForState(ctx, ConnectionConnected); err != nil {
+		t.Fatalf("got error: %v, want: nil", err)
+	}
+
+	var q struct {
+		Hello string
+	}
+	if err := client.Query(ctx, &q, nil); !errors.Is(err, ErrOperationInterrupted) {
+		t.Errorf("got error: %v, want: %v", err, ErrOperationInterrupted)
+	}
+}
+
+func TestSubscriptionClient_LifecycleHooks(t *testing.T) {
+	var connections atomic.Int32
+	_, wsURL := newTestWebsocketServer(t, func(c *websocket.Conn) {
+		n := connections.Add(1)
+		started := 0
+		for {
+			var msg OperationMessage
+			if err := c.ReadJSON(&msg); err != nil {
+				return
+			}
+			switch msg.Type {
+			case GQL_CONNECTION_INIT:
+				_ = c.WriteJSON(map[string]string{"type": string(GQL_CONNECTION_ACK)})
+			case GQL_START:
+				started++
+				if strings.Contains(string(msg.Payload), "bounded") {
+					_ = c.WriteJSON(map[string]string{"id": msg.ID, "type": string(GQL_COMPLETE)})
+				}
+				// drop the first connection once both subscriptions are started
+				if n == 1 && started == 2 {
+					return
+				}
+			}
+		}
+	})
+
+	type reconnecting struct {
+		attempt int
+		cause   error
+	}
+	reconnectings := make(chan reconnecting, 10)
+	resubscribed := make(chan []string, 10)
+	completed := make(chan struct{}, 10)
+	var received, sent sync.Map
+
+	client := NewSubscriptionClient(wsURL).
+		WithTimeout(5 * time.Second).
+		OnReconnecting(func(attempt int, cause error) {
+			reconnectings <- reconnecting{attempt, cause}
+		}).
+		OnResubscribed(func(ids []string) {
+			resubscribed <- ids
+		}).
+		OnMessageReceived(func(message OperationMessage) {
+			received.Store(message.Type, true)
+		}).
+		OnMessageSent(func(message OperationMessage) {
+			sent.Store(message.Type, true)
+		})
+	defer func() { _ = client.Close() }()
+
+	var bounded struct {
+		Bounded string
+	}
+	if _, err := client.Subscribe(&bounded, nil, func(message []byte, err error) error {
+		return nil
+	}, OnComplete(func() {
+		completed <- struct{}{}
+	})); err != nil {
+		t.Fatalf("got error: %v, want: nil", err)
+	}
+	var endless struct {
+		Endless string
+	}
+	id, err := client.Subscribe(&endless, nil, func(message []byte, err error) error {
+		return nil
+	}, OnComplete(func() {
+		t.Error("the subscription isn't completed by the server")
+	}))
+	if err != nil {
+		t.Fatalf("got error: %v, want: nil", err)
+	}
+	go func() { _ = client.Run() }()
+
+	select {
+	case <-completed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the subscription wasn't completed")
+	}
+
+	select {
+	case r := <-reconnectings:
+		if r.attempt != 1 || r.cause == nil {
+			t.Errorf("got attempt: %d, cause: %v, want: 1 and the read error", r.attempt, r.cause)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the client didn't reconnect")
+	}
+
+	select {
+	case ids := <-resubscribed:
+		if !reflect.DeepEqual(ids, []string{id}) {
+			t.Errorf("got resubscribed: %v, want: [%s]", ids, id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the subscriptions weren't resubscribed")
+	}
+
+	for _, msgType := range []OperationMessageType{GQL_CONNECTION_ACK, GQL_COMPLETE} {
+		if _, ok := received.Load(msgType); !ok {
+			t.Errorf("message %s wasn't received", msgType)
+		}
+	}
+	for _, msgType := range []OperationMessageType{GQL_CONNECTION_INIT, GQL_START} {
+		if _, ok := sent.Load(msgType); !ok {
+			t.Errorf("message %s wasn't sent", msgType)
+		}
+	}
+}
+
+func TestSubscriptionClient_ErrorPolicy(t *testing.T) {
+	_, wsURL := newTestWebsocketServer(t, serveTestSubscriptions)
+
+	reported := make(chan error, 10)
+	client := NewSubscriptionClient(wsURL).
+		WithTimeout(5 * time.Second).
+		OnError(func(sc *SubscriptionClient, err error) error {
+			reported <- err
+			return nil
+		})
+	defer func() { _ = client.Close() }()
+
+	errStop := errors.New("stop")
+	errIgnore := errors.New("ignore")
+	errReport := errors.New("report")
+
+	subscribe := func(handlerErr error, options ...Option) string {
+		t.Helper()
+		var sub struct {
+			Test int
+		}
+		id, err := client.Subscribe(&sub, nil, func(message []byte, err error) error {
+			return handlerErr
+		}, options...)
+		if err != nil {
+			t.Fatalf("got error: %v, want: nil", err)
+		}
+		return id
+	}
+
+	stopped := subscribe(errStop, WithErrorPolicy(ErrorPolicyStop))
+	ignored := subscribe(errIgnore, WithErrorPolicy(ErrorPolicyIgnore))
+	unsubscribed := subscribe(fmt.Errorf("done: %w", ErrUnsubscribe))
+	reporting := subscribe(errReport)
+	go func() { _ = client.Run() }()
+
+	select {
+	case err := <-reported:
+		if !errors.Is(err, errReport) {
+			t.Errorf("got reported error: %v, want: %v", err, errReport)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the error wasn't reported")
+	}
+
+	want := map[string]bool{ignored: true, reporting: true}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		client.mu.Lock()
+		got := map[string]bool{}
+		for id := range client.subscriptions {
+			got[id] = true
+		}
+		client.mu.Unlock()
+		if reflect.DeepEqual(got, want) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got subscriptions: %v, want: %v (stopped: %s, unsubscribed: %s)", got, want, stopped, unsubscribed)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	select {
+	case err := <-reported:
+		t.Errorf("got reported error: %v, want: none", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestSubscriptionClient_Deduplication(t *testing.T) {
+	received := make(chan OperationMessage, 20)
+	_, wsURL := newTestWebsocketServer(t, func(c *websocket.Conn) {
+		for {
+			var msg OperationMessage
+			if err := c.ReadJSON(&msg); err != nil {
+				return
+			}
+			received <- msg
+			switch msg.Type {
+			case GQL_CONNECTION_INIT:
+				_ = c.WriteJSON(map[string]string{"type": string(GQL_CONNECTION_ACK)})
+			case GQL_START:
+				_ = c.WriteJSON(map[string]any{
+					"id":      msg.ID,
+					"type":    string(GQL_DATA),
+					"payload": map[string]any{"data": map[string]int{"test": 1}},
+				})
+			}
+		}
+	})
+
+	client := NewSubscriptionClient(wsURL).
+		WithTimeout(5 * time.Second).
+		WithDeduplication(true)
+	defer func() { _ = client.Close() }()
+
+	messages := make(chan string, 10)
+	subscribe := func(variables map[string]any) string {
+		t.Helper()
+		id, err := client.Exec(`subscription ($id: ID!) { test(id: $id) }`, variables, func(message []byte, err error) error {
+			messages <- string(message)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("got error: %v, want: nil", err)
+		}
+		return id
+	}
+	first := subscribe(map[string]any{"id": "1"})
+	second := subscribe(map[string]any{"id": "1"})
+	other := subscribe(map[string]any{"id": "2"})
+	if first == second {
+		t.Fatalf("got the same subscription ID for both subscribers: %s", first)
+	}
+	go func() { _ = client.Run() }()
+
+	// the identical subscriptions share one operation
+	for i := 0; i < 3; i++ {
+		select {
+		case <-messages:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %d messages, want: 3", i)
+		}
+	}
+	starts := map[string]bool{}
+	deadline := time.After(5 * time.Second)
+	for len(starts) < 2 {
+		select {
+		case msg := <-received:
+			if msg.Type == GQL_START {
+				starts[msg.ID] = true
+			}
+		case <-deadline:
+			t.Fatalf("got %d started operations, want: 2", len(starts))
+		}
+	}
+
+	// the operation is stopped with its last subscriber
+	if err := client.Unsubscribe(first); err != nil {
+		t.Fatalf("got error: %v, want: nil", err)
+	}
+	if err := client.Unsubscribe(second); err != nil {
+		t.Fatalf("got error: %v, want: nil", err)
+	}
+	select {
+	case msg := <-received:
+		if msg.Type != GQL_STOP || !starts[msg.ID] {
+			t.Errorf("got message: %s %s, want: stop of a started operation", msg.Type, msg.ID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the operation wasn't stopped")
+	}
+	select {
+	case msg := <-received:
+		t.Errorf("got unexpected message: %s %s", msg.Type, msg.ID)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := client.Unsubscribe(first); err == nil {
+		t.Error("expected error when unsubscribing twice")
+	}
+	if err := client.Unsubscribe(other); err != nil {
+		t.Fatalf("got error: %v, want: nil", err)
+	}
+}
+
+func TestSubscriptionClient_DeduplicationFailedOperation(t *testing.T) {
+	starts := make(chan string, 10)
+	_, wsURL := newTestWebsocketServer(t, func(c *websocket.Conn) {
+		failed := false
+		for {
+			var msg OperationMessage
+			if err := c.ReadJSON(&msg); err != nil {
+				return
+			}
+			switch msg.Type {
+			case GQL_CONNECTION_INIT:
+				_ = c.WriteJSON(map[string]string{"type": string(GQL_CONNECTION_ACK)})
+			case GQL_START:
+				starts <- msg.ID
+				// the first operation fails
+				if !failed {
+					failed = true
+					_ = c.WriteJSON(map[string]any{
+						"id":      msg.ID,
+						"type":    string(GQL_ERROR),
+						"payload": map[string]string{"message": "unavailable"},
+					})
+					continue
+				}
+				_ = c.WriteJSON(map[string]any{
+					"id":      msg.ID,
+					"type":    string(GQL_DATA),
+					"payload": map[string]any{"data": map[string]int{"test": 1}},
+				})
+			}
+		}
+	})
+
+	client := NewSubscriptionClient(wsURL).
+		WithTimeout(5 * time.Second).
+		WithDeduplication(true).
+		OnError(func(sc *SubscriptionClient, err error) error {
+			return nil
+		})
+	defer func() { _ = client.Close() }()
+	go func() { _ = client.Run() }()
+
+	subscribe := func() (string, chan error) {
+		t.Helper()
+		results := make(chan error, 10)
+		id, err := client.Exec(`subscription { test }`, nil, func(message []byte, err error) error {
+			results <- err
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("got error: %v, want: nil", err)
+		}
+		return id, results
+	}
+	expectResult := func(results chan error, wantErr bool) {
+		t.Helper()
+		select {
+		case err := <-results:
+			if (err != nil) != wantErr {
+				t.Fatalf("got error: %v, want error: %v", err, wantErr)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the handler")
+		}
+	}
+
+	first, results := subscribe()
+	expectResult(results, true)
+
+	// an identical subscription starts a new operation instead of joining the failed one
+	_, results = subscribe()
+	expectResult(results, false)
+	if op1, op2 := <-starts, <-starts; op1 == op2 {
+		t.Fatalf("got the same operation for both subscriptions: %s", op1)
+	}
+
+	// unsubscribing from the failed operation doesn't forget the new one
+	if err := client.Unsubscribe(first); err != nil {
+		t.Fatalf("got error: %v, want: nil", err)
+	}
+	subscribe()
+	select {
+	case id := <-starts:
+		t.Errorf("got a new operation %s, want: the running operation is joined", id)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
@@ -12,6 +12,18 @@ const (
 	// construction.
 	ScalarTag = "scalar"
 
+	// GraphQLTypeTag is the struct tag name used on struct variables to
+	// override the inferred GraphQL type of a variable (e.g., "BigInt!").
+	GraphQLTypeTag = "graphql_type"
+
+	// GraphQLDefaultTag is the struct tag name used on struct variables to
+	// declare the default value of a variable (e.g., "10").
+	GraphQLDefaultTag = "graphql_default"
+
+	// GraphQLDepthTag is the struct tag name used on the fields of recursive
+	// types to limit the number of times the field is nested in a query.
+	GraphQLDepthTag = "graphql_depth"
+
 	// TypenameField is the GraphQL introspection field used for type
 	// discrimination in unions and interfaces.
 	TypenameField = "__typename"
@@ -23,3 +23,15 @@ type GraphQLWrapper interface {
 }
 
 var GraphqlWrapperInterface = reflect.TypeOf((*GraphQLWrapper)(nil)).Elem()
+
+// GraphQLFragment interface marks a struct type as a named fragment. When the type is used
+// while creating the GraphQL query, its fields are replaced by the fragment spread (...Name),
+// and a single "fragment Name on TypeCondition" definition is appended to the query.
+//
+// Like GetGraphQLType, the GetGraphQLFragment function is applied to the zero value of the type,
+// so its output should be a constant.
+type GraphQLFragment interface {
+	GetGraphQLFragment() (name string, typeCondition string)
+}
+
+var GraphqlFragmentInterface = reflect.TypeOf((*GraphQLFragment)(nil)).Elem()
@@ -0,0 +1,43 @@
+package graphql
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// DefaultedVariable is a variable of a variables map declared with a default value in the operation definition,
+// e.g. $first:Int = 10. The variable is typed from its value.
+type DefaultedVariable struct {
+	value any
+	// defaultValue is the rendered default value
+	defaultValue string
+	// err is returned by the query construction if the default value can't be rendered
+	err error
+}
+
+// VariableWithDefault declares the variable value with the default value defaultValue.
+// The default value is rendered as a GraphQL value like the arguments of OperationDirective.
+//
+// value is sent in the variables, unless it's an undefined Optional, so that the server uses the default value:
+//
+//	"first": graphql.VariableWithDefault(graphql.Optional[int]{}, 10) // $first:Int = 10, omitted
+func VariableWithDefault(value any, defaultValue any) DefaultedVariable {
+	text, err := renderValue(defaultValue)
+	if err != nil {
+		err = fmt.Errorf("invalid default value: %w", err)
+	}
+	return DefaultedVariable{value: value, defaultValue: text, err: err}
+}
+
+// Value returns the value of the variable
+func (v DefaultedVariable) Value() any {
+	return v.value
+}
+
+// MarshalJSON encodes the value of the variable
+func (v DefaultedVariable) MarshalJSON() ([]byte, error) {
+	return json.Marshal(v.value)
+}
+
+var defaultedVariableType = reflect.TypeOf(DefaultedVariable{})
@@ -0,0 +1,137 @@
+package graphql_test
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/llehouerou/go-graphql-client"
+)
+
+type UserOrder string
+
+func TestVariableWithDefault(t *testing.T) {
+	var q struct {
+		Users []struct {
+			Name string
+		} `graphql:"users(first: $first, orderBy: $orderBy, filter: $filter)"`
+	}
+	variables := map[string]any{
+		"first":   graphql.VariableWithDefault(graphql.Optional[int]{}, 10),
+		"orderBy": graphql.VariableWithDefault(UserOrder("NAME"), graphql.EnumValue("CREATED_AT")),
+		"filter": graphql.VariableWithDefault(
+			(*string)(nil),
+			map[string]any{"active": true, "name": "Go*"},
+		),
+	}
+
+	got, err := graphql.ConstructQuery(&q, variables, graphql.OperationName("ListUsers"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `query ListUsers($filter:String = {active: true, name: "Go*"}$first:Int = 10$orderBy:UserOrder! = CREATED_AT){users(first: $first, orderBy: $orderBy, filter: $filter){name}}`; got != want {
+		t.Errorf("got query: %v, want %v", got, want)
+	}
+
+	// the undefined variable is omitted, so that the server uses the default value
+	req, _, err := graphql.NewClient("/graphql", nil).
+		BuildRequest(context.Background(), "query{x}", variables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := mustRead(req.Body), `{"query":"query{x}","variables":{"filter":null,"orderBy":"NAME"}}`+"\n"; got != want {
+		t.Errorf("got body: %v, want %v", got, want)
+	}
+}
+
+func TestVariableWithDefault_invalid(t *testing.T) {
+	var q struct {
+		Users []struct {
+			Name string
+		} `graphql:"users(ratio: $ratio)"`
+	}
+	variables := map[string]any{
+		"ratio": graphql.VariableWithDefault(1.5, math.NaN()),
+	}
+
+	_, err := graphql.ConstructQuery(&q, variables)
+	if err == nil || !strings.Contains(err.Error(), "variable ratio: invalid default value") {
+		t.Errorf("got error: %v, want invalid default value", err)
+	}
+}
+
+func TestVariableWithDefault_structTag(t *testing.T) {
+	var q struct {
+		Users []struct {
+			Name string
+		} `graphql:"users(first: $first, orderBy: $orderBy, filter: $filter)"`
+	}
+	variables := struct {
+		First   *int      `json:"first,omitempty" graphql_default:"10"`
+		OrderBy UserOrder `json:"orderBy,omitzero" graphql_default:"CREATED_AT"`
+		// undefined Optional fields are omitted
+		Filter graphql.Optional[string] `json:"filter" graphql_default:"\"*\""`
+	}{}
+
+	got, err := graphql.ConstructQuery(&q, variables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `query ($filter:String = "*"$first:Int = 10$orderBy:UserOrder! = CREATED_AT){users(first: $first, orderBy: $orderBy, filter: $filter){name}}`; got != want {
+		t.Errorf("got query: %v, want %v", got, want)
+	}
+
+	// the omitted variables take their default value
+	req, _, err := graphql.NewClient("/graphql", nil).
+		BuildRequest(context.Background(), "query{x}", variables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := mustRead(req.Body), `{"query":"query{x}","variables":{}}`+"\n"; got != want {
+		t.Errorf("got body: %v, want %v", got, want)
+	}
+
+	tests := []struct {
+		name      string
+		variables any
+		wantErr   string
+	}{
+		{
+			name: "invalid literal",
+			variables: struct {
+				First   *int      `json:"first,omitempty" graphql_default:"10px"`
+				OrderBy UserOrder `json:"orderBy,omitempty"`
+				Filter  *string   `json:"filter,omitempty"`
+			}{},
+			wantErr: `variable first: invalid graphql_default tag "10px": invalid number "10p"`,
+		},
+		{
+			name: "variable in default value",
+			variables: struct {
+				First   *int      `json:"first,omitempty" graphql_default:"$limit"`
+				OrderBy UserOrder `json:"orderBy,omitempty"`
+				Filter  *string   `json:"filter,omitempty"`
+			}{},
+			wantErr: `variable first: invalid graphql_default tag "$limit": variables aren't allowed in constant values`,
+		},
+		{
+			name: "variable always sent",
+			variables: struct {
+				First   *int      `json:"first" graphql_default:"10"`
+				OrderBy UserOrder `json:"orderBy,omitempty"`
+				Filter  *string   `json:"filter,omitempty"`
+			}{},
+			wantErr: "variable first: the graphql_default tag requires the omitempty or omitzero json option",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := graphql.ConstructQuery(&q, tt.variables)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error: %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}